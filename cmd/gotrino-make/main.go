@@ -15,19 +15,33 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/golangee/gotrino-make/internal/app"
+	"github.com/golangee/gotrino-make/internal/browser"
 	"github.com/golangee/gotrino-make/internal/builder"
 	"github.com/golangee/gotrino-make/internal/deploy"
+	"github.com/golangee/gotrino-make/internal/deploy/ftp"
+	"github.com/golangee/gotrino-make/internal/deploy/rsync"
+	"github.com/golangee/gotrino-make/internal/fs/sftp"
 	"github.com/golangee/gotrino-make/internal/gotool"
 	"github.com/golangee/gotrino-make/internal/hashtree"
+	"github.com/golangee/gotrino-make/internal/http"
+	"github.com/golangee/gotrino-make/internal/metrics"
+	"github.com/golangee/gotrino-make/internal/scaffold"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime/pprof"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
@@ -44,20 +58,70 @@ func run() error {
 
 	host := flag.String("host", "localhost", "the host to bind on.")
 	port := flag.Int("port", 8080, "the port to bind to for the serve mode.")
+	tlsCert := flag.String("tls-cert", "", "the certificate file to serve https. Requires -tls-key.")
+	tlsKey := flag.String("tls-key", "", "the private key file to serve https. Requires -tls-cert.")
+	tlsAutocert := flag.String("serve-tls-autocert", "", "the domain to request Let's Encrypt certificates for. Serves https on port 443 and redirects http from port 80.")
+	tlsCacheDir := flag.String("tls-cache-dir", defaultAutocertCacheDir(), "the directory to keep the Let's Encrypt certificates between restarts.")
 	wwwDir := flag.String("www", "", "the directory which contains the go wasm module to build.")
 	buildDir := flag.String("dir", "", "the target output build directory. If empty a temporary folder is picked automatically.")
 	debug := flag.Bool("debug", false, "enable debug logging output for gotrino-make.")
 	templatePatterns := flag.String("templatePatterns", ".gohtml,.gocss,.gojs,.gojson,.goxml", "file extensions which should be processed as text/template with BuildInfo.")
-	extra := flag.String("extra", "", "filename to a local json file, which contains extra BuildInfo values. Accessible in templates by {{.Extra}}")
+	safeTemplates := flag.Bool("safe-templates", false, "if set to true, .gohtml and .goxml files are processed as html/template, which escapes all injected values.")
+	var extra fileFlags
+	flag.Var(&extra, "extra", "filename to a local json file, which contains extra BuildInfo values. Accessible in templates by {{.Extra}}. May be repeated: all json objects are merged deeply, so nested objects are combined and later files override conflicting keys of earlier ones.")
+	fastHash := flag.Bool("fast-hash", false, "if set to true, file changes are detected using xxh3 instead of sha256, which is faster but not cryptographically secure.")
 	forceRefresh := flag.Bool("forceRefresh", false, "if set to true, all file hashes are always recalculated for each build instead of relying on ModTime.")
+	reloadDelay := flag.Duration("reload-delay", 200*time.Millisecond, "the delay between a hot reload notification and the reload of the page. Available in templates by {{.ReloadDelayMs}}.")
+	watchDebounce := flag.Duration("watch-debounce", time.Second, "the quiet period after the last file change, before a rebuild is triggered in serve mode, e.g. 500ms or 3s.")
+	atomicOutput := flag.Bool("atomic-output", false, "if set to true, each build is assembled in a staging directory which replaces the output directory at once.")
+	cleanOnError := flag.Bool("clean-on-error", false, "if set to true, the wasm binary and other generated files are removed, if a build fails.")
+	var extraEnv envFlags
+	flag.Var(&extraEnv, "env", "an additional KEY=VALUE environment variable for all go commands, e.g. GONOSUMDB=*.internal.example.com. May be repeated.")
+	buildLog := flag.String("build-log", "", "filename of a log file, which receives the output of all build steps.")
+	buildLogMaxBytes := flag.Int64("build-log-max-bytes", builder.DefaultBuildLogMaxBytes, "the size in bytes at which the build log is rotated into <build-log>.1.")
+	enableMetrics := flag.Bool("metrics", false, "if set to true, prometheus metrics are exported at /metrics of the development server.")
+	verifyCSSClasses := flag.Bool("verify-css-classes", false, "if set to true, the string literals of .gohtml templates, which look like css classes but are not defined by any css file of the output, are reported as {{.CSSWarnings}}.")
+	wasmProfile := flag.String("wasm-profile", "", "mem injects a heap profiler into app.wasm, which exports the JavaScript functions startProfile and stopProfile. Available in templates by {{.WasmProfile}}. Empty disables it. The wasm runtime cannot record cpu profiles.")
+	wasmExecJS := flag.String("wasm-exec-js", "", "a custom wasm_exec.js bridge file, which is provided instead of the GOROOT version.")
+	keepTemplateSources := flag.Bool("keep-template-sources", false, "if set to true, template sources like index.gohtml are kept next to their rendered files in the output.")
+	keepBuilds := flag.Int("keep-builds", 0, "the amount of successful build snapshots to keep for a rollback. 0 keeps none.")
+	failOnStaticConflict := flag.Bool("fail-on-static-conflict", false, "if set to true, the build fails if multiple dependencies provide the same static file.")
+	gzipStatic := flag.Bool("gzip-static", false, "if set to true, all output files larger than 1 KB are pre-compressed into <file>.gz and served to clients accepting gzip.")
+	modTidyCheck := flag.Bool("mod-tidy-check", false, "if set to true, the build fails if go.mod or go.sum are not tidy. Vendored modules are not checked.")
+	skipModTidy := flag.Bool("skip-mod-tidy", false, "if set to true, go mod tidy is not invoked before building, so that builds work without network access if the module cache is pre-populated. Implied by a vendor directory, unless explicitly set to false.")
+	flag.BoolVar(skipModTidy, "no-mod-tidy", false, "an alias of -skip-mod-tidy.")
 	goGenerate := flag.Bool("generate", false, "if set to true, 'go generate' is invoked everytime before building.")
+	goFmt := flag.Bool("gofmt", false, "if set to true, 'gofmt -w' formats the packages of the main module before each build. Like ./..., vendor and testdata directories and nested modules are skipped.")
+	goos := flag.String("goos", "js", "the GOOS to build the wasm module for, e.g. wasip1 for WASI runtimes.")
+	goflags := flag.String("goflags", "", "the GOFLAGS for all go commands, e.g. -modcacherw. Replaces an inherited GOFLAGS. Beware that -insecure fetches modules without verifying TLS certificates, which allows to inject malicious code.")
+	goarch := flag.String("goarch", "wasm", "the GOARCH to build the wasm module for.")
+	serveBasePath := flag.String("serve-base-path", "/", "the URL prefix to serve all assets under, e.g. /app/ behind a reverse proxy. Available in templates by {{.BasePath}}.")
+	profile := flag.String("profile-build", "none", "cpu or mem writes a profile of the initial build to build.prof in the build directory. Inspect it with 'go tool pprof'. none disables profiling.")
+	maxConns := flag.Int("max-conns", 0, "the maximum amount of concurrent connections of the development server. Exceeding connections receive a 503. 0 is unlimited.")
+	corsOrigins := corsFlags{origins: []string{"*"}}
+	flag.Var(&corsOrigins, "cors-origin", "an origin, which is allowed to fetch from the development server, e.g. http://localhost:6006. May be repeated. * allows any origin.")
+	corsMethods := flag.String("cors-methods", "GET,POST,HEAD,OPTIONS", "the comma separated methods allowed for cross-origin requests to the development server.")
+	desktopNotify := flag.Bool("desktop-notify", false, "if set to true, a desktop notification is shown after each build. Requires osascript on macOS, notify-send on Linux or powershell on Windows.")
+	notifyURL := flag.String("notify-url", "", "a webhook URL, which receives a POST with the json build result after each build, e.g. {\"hash\":\"...\",\"error\":null,\"duration_ms\":1234,\"commit\":\"...\"}.")
+	notifySecret := flag.String("notify-url-secret", "", "a secret, which is sent as bearer token in the Authorization header to the -notify-url.")
+	openBrowser := flag.Bool("open-browser", false, "if set to true, the development server URL is opened in the default browser when serve starts.")
+	maxWasmSize := flag.Int64("max-wasm-size", 0, "the maximum size of the compiled app.wasm in bytes. The build fails if exceeded. 0 disables the check.")
 	deployHost := flag.String("deploy-host", "", "the host to deploy to")
 	deployPwd := flag.String("deploy-password", "", "the host password to deploy to")
+	deployKey := flag.String("deploy-key", "", "path to SSH private key for SFTP deployment")
+	deployKeyPassphrase := flag.String("deploy-key-passphrase", "", "passphrase for SSH private key")
 	deployUser := flag.String("deploy-user", "", "the host user to deploy to")
 	deploySrc := flag.String("deploy-src", "", "the local folder to upload")
 	deployDst := flag.String("deploy-dst", ".", "the remote folder to upload")
-	deployPrt := flag.Int("deploy-port", 22, "the remote port (e.g. ftp is usually 21 and sftp (SSH file Transfer Protocol) is 22)")
-	//deploySkipVerify := flag.Bool("deploy-skip-verify", false, "accept invalid certificates")
+	deployPrt := flag.Int("deploy-port", 0, "the remote port. 0 uses the default port of the protocol, which is 21 for ftp and 22 for sftp (SSH file Transfer Protocol)")
+	deploySSHAgent := flag.Bool("deploy-ssh-agent", false, "authenticate sftp deployments using the ssh agent from SSH_AUTH_SOCK")
+	deployKeepAlive := flag.Duration("deploy-keep-alive", sftp.DefaultKeepAliveInterval, "the interval of sftp keep-alive requests. 0 disables keep-alive.")
+	minGoVersion := flag.String("min-go-version", "", "the minimum required go version, e.g. 1.21. If empty, the go directive from the go.mod is used.")
+	deploySkipVerify := flag.Bool("deploy-skip-verify", false, "accept invalid certificates")
+	var deployExclude globFlags
+	flag.Var(&deployExclude, "deploy-exclude", "a glob of files, which are not uploaded by deploy-ftp, deploy-sftp and deploy-rsync, e.g. *.map or testdata/**. It is matched against the file name, the relative path and its parent directories. May be repeated.")
+	deployConcurrency := flag.Int("deploy-concurrency", 1, "the amount of parallel sftp uploads")
+	deployPreservePerms := flag.Bool("deploy-preserve-permissions", true, "apply the permission bits of local files to the uploaded sftp files")
 
 	flag.Parse()
 
@@ -65,29 +129,64 @@ func run() error {
 	hashtree.Debug = *debug
 	gotool.Debug = *debug
 	deploy.Debug = *debug
+	rsync.Debug = *debug
+	ftp.Debug = *debug
+
+	if *enableMetrics {
+		metrics.Enable()
+	}
 
 	action := ""
-	if len(flag.Args()) == 1 {
-		action = flag.Args()[len(flag.Args())-1]
+	if len(flag.Args()) > 0 {
+		action = flag.Args()[0]
 	}
 
 	opts := builder.Options{}
 	opts.TemplatePatterns = strings.Split(*templatePatterns, ",")
 	opts.Force = *forceRefresh
+	opts.FastHash = *fastHash
 	opts.HotReload = action == "serve"
 	opts.Debug = *debug
 	opts.GoGenerate = *goGenerate
+	opts.GoFmt = *goFmt
+	opts.DesktopNotify = *desktopNotify
+	opts.NotifyURL = *notifyURL
+	opts.NotifySecret = *notifySecret
+	opts.MaxWasmSizeBytes = *maxWasmSize
+	opts.SafeTemplates = *safeTemplates
+	opts.WatchDebounce = *watchDebounce
+	opts.ReloadDelay = *reloadDelay
+	opts.GOOS = *goos
+	opts.GOARCH = *goarch
+	opts.GOFlags = *goflags
+	opts.AtomicOutput = *atomicOutput
+	opts.CleanOnError = *cleanOnError
+	opts.ExtraEnv = extraEnv
+	opts.BuildLogFile = *buildLog
+	opts.BuildLogMaxBytes = *buildLogMaxBytes
+	opts.GoModTidyCheck = *modTidyCheck
+	opts.SkipModTidy = *skipModTidy
+	opts.TidyVendored = isFlagSet("skip-mod-tidy", "no-mod-tidy") && !*skipModTidy
+	opts.GzipStatic = *gzipStatic
+	opts.KeepBuilds = *keepBuilds
+	opts.KeepTemplateSources = *keepTemplateSources
+	opts.WasmExecJS = *wasmExecJS
+	opts.WasmProfile = *wasmProfile
+	opts.VerifyCSSClasses = *verifyCSSClasses
+	opts.FailOnStaticConflict = *failOnStaticConflict
+	opts.BasePath = "/" + strings.Trim(*serveBasePath, "/")
 
-	if *extra != "" {
-		buf, err := ioutil.ReadFile(*extra)
-		if err != nil {
-			return fmt.Errorf("unable to open extra file: %w", err)
-		}
+	if opts.GOOS != "js" && opts.GOOS != "wasip1" {
+		log.Println(fmt.Sprintf("warning: GOOS=%s is not a web assembly target, the served app.wasm will not work in a browser", opts.GOOS))
+	}
 
-		err = json.Unmarshal(buf, &opts.Extra)
+	if len(extra) > 0 {
+		v, err := loadExtra(extra)
 		if err != nil {
-			return fmt.Errorf("unable to unmarshal json from extra file: %w", err)
+			return err
 		}
+
+		opts.Extra = v
 	}
 
 	if *buildDir == "" {
@@ -106,50 +205,702 @@ func run() error {
 		*wwwDir = filepath.Join(cwd, *wwwDir)
 	}
 
-	// strip absolute slash, otherwise we would
+	// strip absolute slash for the io/fs based deployments, otherwise we would
 	// violate https://go.googlesource.com/proposal/+/master/design/draft-iofs.md#file-name-syntax
-	if strings.HasPrefix(*deploySrc, "/") {
-		*deploySrc = (*deploySrc)[1:]
+	fsDeploySrc := strings.TrimPrefix(*deploySrc, "/")
+
+	if action == "serve" || action == "build" {
+		if err := validateEnvironment(opts); err != nil {
+			return err
+		}
+
+		if err := checkGoVersion(*minGoVersion, *wwwDir); err != nil {
+			return err
+		}
+	}
+
+	sftpOpts := sftp.Options{
+		Host:              *deployHost,
+		Port:              *deployPrt,
+		User:              *deployUser,
+		Password:          *deployPwd,
+		UseSSHAgent:       *deploySSHAgent,
+		KeyFile:           *deployKey,
+		KeyPassphrase:     *deployKeyPassphrase,
+		KeepAliveInterval: *deployKeepAlive,
 	}
 
-	if len(flag.Args()) == 1 {
+	if len(flag.Args()) > 0 {
 
 		switch action {
 		case "deploy-ftp":
-			/*err := ftp.Upload(*deployHost, *deployUser, *deployPwd, *deploySrc, *deployDst, *deployPrt, *debug, *deploySkipVerify)
+			ftpOpts := ftp.FTPOptions{
+				Host:               *deployHost,
+				Port:               *deployPrt,
+				User:               *deployUser,
+				Password:           *deployPwd,
+				InsecureSkipVerify: *deploySkipVerify,
+			}
+
+			summary, err := ftp.SyncFTP(ftpOpts, *deploySrc, *deployDst, deployExclude...)
+			log.Println(summary)
 			if err != nil {
 				return fmt.Errorf("unable to deploy-ftp: %w", err)
-			}*/
-			panic("implement me")
+			}
 		case "deploy-sftp":
-			err := deploy.SyncSFTP(*deployDst, *deploySrc, *deployHost, *deployUser, *deployPwd, *deployPrt)
+			summary, err := deploy.SyncSFTP(*deployDst, fsDeploySrc, sftpOpts, syncOptions(*debug, *deployPreservePerms, *deployConcurrency, deployExclude))
+			log.Println(summary)
 			if err != nil {
 				return fmt.Errorf("unable to deploy-ftp: %w", err)
 			}
+		case "deploy-rsync":
+			syncOpts := syncOptions(*debug, *deployPreservePerms, *deployConcurrency, deployExclude)
+			syncOpts.Upload = rsync.Upload
+			summary, err := deploy.SyncSFTP(*deployDst, fsDeploySrc, sftpOpts, syncOpts)
+			log.Println(summary)
+			if err != nil {
+				return fmt.Errorf("unable to deploy-rsync: %w", err)
+			}
 		case "serve":
-			a, err := app.NewApplication(*host, *port, *wwwDir, *buildDir, opts)
+			a, err := profileApplication(*profile, *buildDir, func() (*app.Application, error) {
+				return app.NewApplication(*host, *port, *wwwDir, *buildDir, opts)
+			})
+
 			if err != nil {
 				return err
 			}
 
 			defer a.Close()
 
+			a.SetMaxConns(*maxConns)
+			a.SetCORS(corsOrigins.origins, strings.Split(*corsMethods, ","))
+			a.SetTLS(http.TLSOptions{
+				CertFile:         *tlsCert,
+				KeyFile:          *tlsKey,
+				AutocertDomain:   *tlsAutocert,
+				AutocertCacheDir: *tlsCacheDir,
+			})
+
+			if *openBrowser {
+				url := fmt.Sprintf("http://%s:%d%s", *host, *port, opts.BasePath)
+				if err := browser.Open(url); err != nil {
+					log.Println(fmt.Sprintf("warning: %v", err))
+				}
+			}
+
 			return a.Run()
 		case "build":
-			a, err := app.NewApplication(*host, *port, *wwwDir, *buildDir, opts)
+			buildFlags := flag.NewFlagSet("build", flag.ExitOnError)
+			verify := buildFlags.Bool("verify", false, "if set to true, the build is repeated with -forceRefresh into a temporary directory and both outputs must be bit-for-bit identical.")
+			forceRebuild := buildFlags.Bool("force-rebuild", false, "if set to true, the compiler is invoked even if the source hash is unchanged.")
+			buildCount := buildFlags.Int("build-count", 1, "the amount of full builds to benchmark. Larger than 1 prints the min, max, mean and P99 durations of each phase to stderr.")
+			printHash := buildFlags.Bool("print-build-hash", false, "if set to true, only the hex build hash is printed to stdout after a successful build. Fails with exit code 1 otherwise.")
+			if err := buildFlags.Parse(flag.Args()[1:]); err != nil {
+				return err
+			}
+
+			opts.Reproducible = *verify
+			opts.ForceRebuild = *forceRebuild
+
+			if *buildCount > 1 {
+				return benchmarkBuild(app.BuildOutputDir(*buildDir), *wwwDir, opts, *buildCount)
+			}
+
+			if *printHash {
+				hash, err := buildHash(app.BuildOutputDir(*buildDir), *wwwDir, opts)
+				if err != nil {
+					log.Println(err.Error())
+					os.Exit(1)
+				}
+
+				fmt.Println(hash)
+
+				return nil
+			}
+
+			a, err := profileApplication(*profile, *buildDir, func() (*app.Application, error) {
+				return app.NewApplication(*host, *port, *wwwDir, *buildDir, opts)
+			})
+
 			if err != nil {
 				return err
 			}
 
 			defer a.Close()
+
+			if *verify {
+				if err := verifyBuild(app.BuildOutputDir(*buildDir), *wwwDir, opts); err != nil {
+					return err
+				}
+			}
+		case "replace":
+			if len(flag.Args()) != 3 {
+				return fmt.Errorf("usage: gotrino-make replace <module>[@version] <local dir>")
+			}
+
+			if err := replaceModule(*wwwDir, flag.Args()[1], flag.Args()[2], opts.GoEnv()); err != nil {
+				return fmt.Errorf("unable to replace module: %w", err)
+			}
+		case "dropreplace":
+			if len(flag.Args()) != 2 {
+				return fmt.Errorf("usage: gotrino-make dropreplace <module>[@version]")
+			}
+
+			if err := gotool.ModDropReplace(*wwwDir, flag.Args()[1]); err != nil {
+				return fmt.Errorf("unable to drop replace: %w", err)
+			}
+
+			if _, err := gotool.ModTidy(*wwwDir, opts.GoEnv()...); err != nil {
+				return fmt.Errorf("unable to go mod tidy: %w", err)
+			}
+		case "init":
+			if len(flag.Args()) > 2 {
+				return fmt.Errorf("usage: gotrino-make -www=<dir> init [module]")
+			}
+
+			module := ""
+			if len(flag.Args()) == 2 {
+				module = flag.Args()[1]
+			}
+
+			if err := initProject(*wwwDir, module, opts.GoEnv()); err != nil {
+				return fmt.Errorf("unable to init project: %w", err)
+			}
+		case "pin":
+			if len(flag.Args()) != 3 {
+				return fmt.Errorf("usage: gotrino-make pin <module> <version>")
+			}
+
+			if err := pinModule(*wwwDir, flag.Args()[1], flag.Args()[2], opts.GoEnv()); err != nil {
+				return fmt.Errorf("unable to pin module: %w", err)
+			}
+		case "list-builds":
+			snapshots, err := builder.ListSnapshots(app.BuildOutputDir(*buildDir))
+			if err != nil {
+				return err
+			}
+
+			for _, snapshot := range snapshots {
+				fmt.Printf("%s %s\n", snapshot.Time.Local().Format(time.RFC3339), snapshot.Hash)
+			}
+		case "list-modules":
+			if err := listModules(*wwwDir, *debug, opts.GoEnv()); err != nil {
+				return err
+			}
+		case "rollback":
+			rollbackFlags := flag.NewFlagSet("rollback", flag.ExitOnError)
+			to := rollbackFlags.String("to", "", "the hash or a unique hash prefix of the build snapshot to restore.")
+			if err := rollbackFlags.Parse(flag.Args()[1:]); err != nil {
+				return err
+			}
+
+			if err := builder.Rollback(app.BuildOutputDir(*buildDir), *to); err != nil {
+				return fmt.Errorf("unable to rollback: %w", err)
+			}
 		case "clean":
 			if err := os.RemoveAll(*buildDir); err != nil {
 				log.Fatalf("cannot clean build dir: %w", err)
 			}
 		default:
-			log.Fatalf("you must provide an action: serve | build | clean | deploy-sftp")
+			log.Fatalf("you must provide an action: serve | build | clean | init | list-builds | list-modules | rollback | deploy-ftp | deploy-sftp | deploy-rsync | replace | dropreplace | pin")
+		}
+
+	}
+
+	return nil
+}
+
+// profileApplication profiles the creation of the application, which includes the initial build. The profile
+// is written into the build directory.
+func profileApplication(kind, buildDir string, create func() (*app.Application, error)) (*app.Application, error) {
+	if kind == "" || kind == "none" {
+		return create()
+	}
+
+	if kind != "cpu" && kind != "mem" {
+		return nil, fmt.Errorf("invalid profile-build value: '%s', must be cpu, mem or none", kind)
+	}
+
+	if err := os.MkdirAll(buildDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create build dir: %w", err)
+	}
+
+	fname := filepath.Join(buildDir, "build.prof")
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create profile: %w", err)
+	}
+
+	defer f.Close()
+
+	if kind == "cpu" {
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return nil, fmt.Errorf("unable to start cpu profile: %w", err)
+		}
+	}
+
+	a, err := create()
+
+	if kind == "cpu" {
+		pprof.StopCPUProfile()
+	} else if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Println(fmt.Sprintf("warning: unable to write heap profile: %v", err))
+	}
+
+	if err := f.Close(); err != nil {
+		log.Println(fmt.Sprintf("warning: unable to write profile: %v", err))
+	} else {
+		log.Println(fmt.Sprintf("%s profile of the build written to %s, inspect with 'go tool pprof %s'", kind, fname, fname))
+	}
+
+	return a, err
+}
+
+// listModules prints all modules of the build as a json array. With debug, the modules providing static files
+// are logged as well.
+func listModules(modDir string, debug bool, env []string) error {
+	type Replace struct {
+		Dir string `json:"dir"`
+	}
+
+	type Module struct {
+		Path    string  `json:"path"`
+		Version string  `json:"version"`
+		Dir     string  `json:"dir"`
+		Main    bool    `json:"main"`
+		Replace Replace `json:"replace"`
+	}
+
+	mods, err := gotool.ModList(modDir, env...)
+	if err != nil {
+		return fmt.Errorf("unable to list modules: %w", err)
+	}
+
+	res := make([]Module, 0, len(mods))
+	for _, mod := range mods {
+		res = append(res, Module{
+			Path:    mod.Path,
+			Version: mod.Version,
+			Dir:     mod.Dir,
+			Main:    mod.Main,
+			Replace: Replace{Dir: mod.Replace.Dir},
+		})
+
+		if !debug || mod.Dir == "" {
+			continue
+		}
+
+		// by convention, the static folder of each module is merged into the build output
+		if stat, err := os.Stat(filepath.Join(mod.Dir, "static")); err == nil && stat.IsDir() {
+			log.Println(fmt.Sprintf("static files provided by %s", mod.Path))
+		}
+	}
+
+	buf, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal modules: %w", err)
+	}
+
+	fmt.Println(string(buf))
+
+	return nil
+}
+
+// buildHash builds the source module into dstPath and returns the hex build hash, which equals the
+// BuildInfo.Version. Compile errors fail the build.
+func buildHash(dstPath, srcPath string, opts builder.Options) (string, error) {
+	prj, err := builder.NewProject(dstPath, srcPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to create project: %w", err)
+	}
+
+	defer prj.Close()
+
+	if _, err := prj.Build(opts); err != nil {
+		return "", fmt.Errorf("unable to build: %w", err)
+	}
+
+	return prj.LastVersion(), nil
+}
+
+// verifyBuild repeats the build and reports all files, which are not reproducible.
+func verifyBuild(dstPath, srcPath string, opts builder.Options) error {
+	diff, err := builder.Verify(dstPath, srcPath, opts)
+	if err != nil {
+		return fmt.Errorf("unable to verify build: %w", err)
+	}
+
+	if len(diff) == 0 {
+		log.Println("build verified: the output is reproducible")
+		return nil
+	}
+
+	for _, file := range diff {
+		log.Println(fmt.Sprintf("not reproducible: %s", file))
+	}
+
+	return fmt.Errorf("build is not reproducible: %d files differ", len(diff))
+}
+
+// benchmarkBuild repeats the full build and prints the statistics of each phase as a table to stderr.
+func benchmarkBuild(dstPath, srcPath string, opts builder.Options, count int) error {
+	start := time.Now()
+	res, err := builder.Benchmark(dstPath, srcPath, opts, count)
+	if err != nil {
+		return fmt.Errorf("unable to benchmark build: %w", err)
+	}
+
+	phases := []struct {
+		name     string
+		duration func(t builder.BuildTimings) time.Duration
+	}{
+		{"total", func(t builder.BuildTimings) time.Duration { return t.Total }},
+		{"hash", func(t builder.BuildTimings) time.Duration { return t.Hash }},
+		{"compile", func(t builder.BuildTimings) time.Duration { return t.Compile }},
+		{"template", func(t builder.BuildTimings) time.Duration { return t.Template }},
+	}
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "phase\tmin\tmax\tmean\tp99\n")
+	for _, phase := range phases {
+		stats := res.Stats(phase.duration)
+		fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\n", phase.name, stats.Min.Round(time.Millisecond),
+			stats.Max.Round(time.Millisecond), stats.Mean.Round(time.Millisecond), stats.P99.Round(time.Millisecond))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d builds in %v\n", count, time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// envFlags is a repeatable flag of KEY=VALUE environment variables.
+type envFlags []string
+
+func (e *envFlags) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *envFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected KEY=VALUE but got '%s'", value)
+	}
+
+	*e = append(*e, value)
+
+	return nil
+}
+
+// defaultAutocertCacheDir returns $HOME/.gotrino/autocert.
+func defaultAutocertCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".gotrino", "autocert")
+	}
+
+	return filepath.Join(home, ".gotrino", "autocert")
+}
+
+// fileFlags is a repeatable flag of file names.
+type fileFlags []string
+
+func (f *fileFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// isFlagSet returns true, if any of the named flags has been given on the command line.
+func isFlagSet(names ...string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
+			}
+		}
+	})
+
+	return set
+}
+
+// globFlags is a repeatable flag of path.Match patterns.
+type globFlags []string
+
+func (g *globFlags) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globFlags) Set(value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return fmt.Errorf("invalid glob '%s': %w", value, err)
+	}
+
+	*g = append(*g, value)
+
+	return nil
+}
+
+// corsFlags is a repeatable flag of origins. The first set value replaces the default.
+type corsFlags struct {
+	origins []string
+	set     bool
+}
+
+func (c *corsFlags) String() string {
+	if c == nil {
+		return ""
+	}
+
+	return strings.Join(c.origins, ",")
+}
+
+func (c *corsFlags) Set(value string) error {
+	if !c.set {
+		c.origins = nil
+		c.set = true
+	}
+
+	c.origins = append(c.origins, value)
+
+	return nil
+}
+
+// loadExtra reads all json files and merges them deeply in order. A single file may contain any json value,
+// but multiple files must contain json objects.
+func loadExtra(files []string) (interface{}, error) {
+	var res interface{}
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open extra file: %w", err)
 		}
 
+		var v interface{}
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal json from extra file: %s: %w", file, err)
+		}
+
+		if res == nil {
+			res = v
+			continue
+		}
+
+		dst, ok := res.(map[string]interface{})
+		src, ok2 := v.(map[string]interface{})
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("unable to merge extra file: %s: multiple extra files must contain json objects", file)
+		}
+
+		mergeJSON(dst, src)
+	}
+
+	return res, nil
+}
+
+// mergeJSON deeply merges src into dst. Nested objects are merged recursively, all other values of src
+// replace the values of dst.
+func mergeJSON(dst, src map[string]interface{}) {
+	for key, srcVal := range src {
+		srcObj, srcIsObj := srcVal.(map[string]interface{})
+		dstObj, dstIsObj := dst[key].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			mergeJSON(dstObj, srcObj)
+			continue
+		}
+
+		dst[key] = srcVal
+	}
+}
+
+// syncOptions returns the deployment options. Without debug logging, a progress bar is printed to stderr.
+func syncOptions(debug, preservePermissions bool, concurrency int, excludeGlobs []string) deploy.SyncOptions {
+	opts := deploy.SyncOptions{PreservePermissions: preservePermissions, Concurrency: concurrency, ExcludeGlobs: excludeGlobs}
+	if !debug {
+		opts.OnProgress = printProgress
+	}
+
+	return opts
+}
+
+// printProgress overwrites the current line on stderr with a progress bar.
+func printProgress(uploaded, total int, currentFile string) {
+	const width = 30
+	filled := width
+	if total > 0 {
+		filled = uploaded * width / total
+	}
+
+	if len(currentFile) > 40 {
+		currentFile = "..." + currentFile[len(currentFile)-37:]
+	}
+
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %d/%d %-40s", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), uploaded, total, currentFile)
+	if uploaded >= total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// replaceModule adds a replace directive for the module old to the go.mod in modDir. A relative newLocal
+// directory is interpreted relative to the current working directory and is written relative to modDir.
+func replaceModule(modDir, old, newLocal string, extraEnv []string) error {
+	if !filepath.IsAbs(newLocal) {
+		abs, err := filepath.Abs(newLocal)
+		if err != nil {
+			return fmt.Errorf("unable to resolve local dir: %w", err)
+		}
+
+		rel, err := filepath.Rel(modDir, abs)
+		if err != nil {
+			return fmt.Errorf("unable to relativize local dir: %w", err)
+		}
+
+		newLocal = filepath.ToSlash(rel)
+		if !strings.HasPrefix(newLocal, "../") {
+			newLocal = "./" + newLocal
+		}
+	}
+
+	if err := gotool.ModReplace(modDir, old, newLocal); err != nil {
+		return err
+	}
+
+	if _, err := gotool.ModTidy(modDir, extraEnv...); err != nil {
+		return fmt.Errorf("unable to go mod tidy: %w", err)
+	}
+
+	return nil
+}
+
+// pinModule pins the module to the version and prints the changed lines of the go.mod in modDir.
+func pinModule(modDir, path, version string, extraEnv []string) error {
+	modFile := filepath.Join(modDir, "go.mod")
+	before, err := ioutil.ReadFile(modFile)
+	if err != nil {
+		return fmt.Errorf("unable to read go.mod: %w", err)
+	}
+
+	if err := gotool.ModPin(modDir, path, version, extraEnv...); err != nil {
+		return err
+	}
+
+	after, err := ioutil.ReadFile(modFile)
+	if err != nil {
+		return fmt.Errorf("unable to read go.mod: %w", err)
+	}
+
+	for _, line := range gotool.DiffLines(string(before), string(after)) {
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+// initProject scaffolds a new project in modDir and fetches its dependencies. Without a module name, the user
+// is asked for it and the name of modDir is proposed.
+func initProject(modDir, module string, extraEnv []string) error {
+	if module == "" {
+		proposal := filepath.Base(modDir)
+		fmt.Printf("module name [%s]: ", proposal)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("unable to read module name: %w", err)
+		}
+
+		module = strings.TrimSpace(line)
+		if module == "" {
+			module = proposal
+		}
+	}
+
+	goVersion := ""
+	if v, err := gotool.Version(); err == nil {
+		if major, minor, _, err := gotool.ParseVersion(v); err == nil {
+			goVersion = fmt.Sprintf("%d.%d", major, minor)
+		}
+	}
+
+	if err := scaffold.Init(modDir, module, goVersion); err != nil {
+		return err
+	}
+
+	if _, err := gotool.ModTidy(modDir, extraEnv...); err != nil {
+		return fmt.Errorf("unable to go mod tidy: %w", err)
+	}
+
+	log.Println(fmt.Sprintf("created %s in %s, run 'gotrino-make -www=%s serve' to start", module, modDir, modDir))
+
+	return nil
+}
+
+// A tool describes an external program, which is invoked while building.
+type tool struct {
+	name     string
+	hint     string
+	required func(opts builder.Options) bool // required returns true, if a build will fail without the tool.
+}
+
+// tools contains all known external programs.
+var tools = []tool{
+	{
+		name:     "go",
+		hint:     "install go from https://golang.org/dl/ and ensure that it is in your PATH",
+		required: func(opts builder.Options) bool { return true },
+	},
+	{
+		name:     "git",
+		hint:     "install git from https://git-scm.com/downloads to include the commit into the BuildInfo",
+		required: func(opts builder.Options) bool { return false },
+	},
+}
+
+// validateEnvironment checks that all tools required to build with the given options are available. Missing
+// optional tools are only logged.
+func validateEnvironment(opts builder.Options) error {
+	for _, t := range tools {
+		path, err := exec.LookPath(t.name)
+		if err == nil {
+			if opts.Debug {
+				log.Println(fmt.Sprintf("found %s at %s", t.name, path))
+			}
+
+			continue
+		}
+
+		if t.required(opts) {
+			return fmt.Errorf("required tool '%s' not found: %s", t.name, t.hint)
+		}
+
+		log.Println(fmt.Sprintf("warning: optional tool '%s' not found: %s", t.name, t.hint))
+	}
+
+	return nil
+}
+
+// checkGoVersion ensures that the installed go version is at least minVersion. If minVersion is empty,
+// the go directive of the go.mod in modDir is used instead.
+func checkGoVersion(minVersion, modDir string) error {
+	if minVersion == "" {
+		v, err := gotool.ModGoVersion(modDir)
+		if err != nil {
+			log.Println("unable to read go directive", err)
+		}
+
+		minVersion = v
+	}
+
+	if minVersion == "" {
+		return nil
+	}
+
+	if err := gotool.CheckMinVersion(minVersion); err != nil {
+		return fmt.Errorf("unsupported go version: %w", err)
 	}
 
 	return nil
@@ -6,8 +6,13 @@ require (
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golangee/log v0.0.0-20201214095632-610ba2dec6e5
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/pkg/sftp v1.12.0
+	github.com/prometheus/client_golang v1.12.1
 	github.com/worldiety/go-tip v0.0.0-20201218150903-d4b33a75c52b
+	github.com/zeebo/xxh3 v1.0.2
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	golang.org/x/sync v0.1.0
 	gopkg.in/dutchcoders/goftp.v1 v1.0.0-20170301105846-ed59a591ce14
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -32,7 +32,6 @@ type Application struct {
 	server  *http.Server
 	logger  log.Logger
 	builder *livebuilder.Builder
-	tmpDir  string
 }
 
 func NewApplication(host string, port int, wwwDir, buildDir string, opts builder2.Options) (*Application, error) {
@@ -46,7 +45,7 @@ func NewApplication(host string, port int, wwwDir, buildDir string, opts builder
 	a.logger = log.NewLogger(ecs.Log("application"))
 
 	a.logger.Println(ecs.Msg("build dir " + tmpDir))
-	wwwBuildDir := filepath.Join(tmpDir, "www")
+	wwwBuildDir := BuildOutputDir(tmpDir)
 
 	if opts.Debug {
 		a.logger.Println(fmt.Sprintf("frontend source directory: %s", wwwDir))
@@ -58,6 +57,9 @@ func NewApplication(host string, port int, wwwDir, buildDir string, opts builder
 	}
 
 	a.server = http.NewServer(log.WithFields(a.logger, ecs.Log("httpserver")), host, port, wwwBuildDir)
+	a.server.SetBasePath(opts.BasePath)
+	a.server.WasmPath = "app.wasm"
+	opts.PauseServing = a.server.Pause
 	builder, err := livebuilder.NewBuilder(wwwBuildDir, wwwDir, func(hash string) {
 		a.server.NotifyChanged(hash)
 	}, opts)
@@ -65,6 +67,13 @@ func NewApplication(host string, port int, wwwDir, buildDir string, opts builder
 		return nil, err
 	}
 	a.builder = builder
+	a.server.SetWatcher(builder)
+	a.server.SetResetter(builder)
+	a.server.SetBuildProgress(builder)
+	a.server.SetStatsProvider(func() http.BuildStats {
+		return http.BuildStats(builder.Stats())
+	})
+	a.server.SetHashProvider(builder.FileHash)
 	if err := a.builder.Build(); err != nil {
 		buildErr := builder2.CompileErr{}
 		if errors.As(err, &buildErr) {
@@ -77,6 +86,11 @@ func NewApplication(host string, port int, wwwDir, buildDir string, opts builder
 	return a, nil
 }
 
+// BuildOutputDir returns the directory within the build directory, which contains the served output.
+func BuildOutputDir(buildDir string) string {
+	return filepath.Join(buildDir, "www")
+}
+
 func (a *Application) initCloseListener() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -86,6 +100,22 @@ func (a *Application) initCloseListener() {
 	}()
 }
 
+// SetTLS enables https for the development server.
+func (a *Application) SetTLS(opts http.TLSOptions) {
+	a.server.SetTLS(opts)
+}
+
+// SetMaxConns limits the concurrent connections of the development server. 0 is unlimited.
+func (a *Application) SetMaxConns(maxConns int) {
+	a.server.MaxConns = maxConns
+}
+
+// SetCORS allows cross-origin requests from the given origins or * with the given methods.
+func (a *Application) SetCORS(origins, methods []string) {
+	a.server.CORSOrigins = origins
+	a.server.CORSMethods = methods
+}
+
 func (a *Application) Run() error {
 	defer func() {
 		a.logger.Println(ecs.Msg("exiting"))
@@ -94,7 +124,13 @@ func (a *Application) Run() error {
 	return a.server.Run()
 }
 
+// Close stops the server and the builder. The build directory is kept, because it contains the output for a
+// deployment and the build snapshots. See also the clean action.
 func (a *Application) Close() error {
 	a.server.Stop()
-	return os.RemoveAll(a.tmpDir)
+	if err := a.builder.Close(); err != nil {
+		a.logger.Println(ecs.Msg("failed to close builder"), ecs.ErrMsg(err))
+	}
+
+	return nil
 }
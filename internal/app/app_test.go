@@ -0,0 +1,144 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	builder2 "github.com/golangee/gotrino-make/internal/builder"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const generator = `//go:build ignore
+
+package main
+
+import "io/ioutil"
+
+func main() {
+	if err := ioutil.WriteFile("generated.txt", []byte("ok"), 0644); err != nil {
+		panic(err)
+	}
+}
+`
+
+func TestNewApplicationGoGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("invokes the go toolchain")
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+
+	if _, err := os.Stat(builder2.GoRootBridge(runtime.GOROOT())); err != nil {
+		t.Skip("GOROOT provides no wasm_exec.js")
+	}
+
+	srcDir, err := ioutil.TempDir("", "app-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(srcDir)
+
+	files := map[string]string{
+		"go.mod":              "module example.com/app\n\ngo 1.15\n",
+		"cmd/wasm/main.go":    "package main\n\n//go:generate go run gen.go\n\nfunc main() {}\n",
+		"cmd/wasm/gen.go":     generator,
+		"static/index.gohtml": "<html></html>",
+	}
+
+	for name, content := range files {
+		fname := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buildDir, err := ioutil.TempDir("", "app-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(buildDir)
+
+	opts := builder2.Options{GoGenerate: true, TemplatePatterns: []string{".gohtml"}}
+	a, err := NewApplication("localhost", 0, srcDir, buildDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Close()
+
+	if _, err := os.Stat(filepath.Join(srcDir, "cmd/wasm/generated.txt")); err != nil {
+		t.Fatalf("expected go generate to be invoked by the initial build: %v", err)
+	}
+}
+
+func TestCloseKeepsSnapshots(t *testing.T) {
+	if testing.Short() {
+		t.Skip("invokes the go toolchain")
+	}
+
+	buildDir, err := ioutil.TempDir("", "app-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(buildDir)
+
+	// the fixture has no dependencies, so that tidy is not required
+	opts := builder2.Options{SkipModTidy: true, KeepBuilds: 2, TemplatePatterns: []string{".gohtml"}}
+	a, err := NewApplication("localhost", 0, "../../testdata/hello-wasm", buildDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := BuildOutputDir(buildDir)
+	if err := os.RemoveAll(outDir); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := builder2.ListSnapshots(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot after close but got %d", len(snapshots))
+	}
+
+	if err := builder2.Rollback(outDir, snapshots[0].Hash[:8]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app.wasm", "index.html"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Fatalf("expected %s to be restored: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DurationStats summarizes the durations of a phase over multiple builds.
+type DurationStats struct {
+	Min, Max, Mean, P99 time.Duration
+}
+
+// BenchmarkResult contains the timings of each build of a Benchmark.
+type BenchmarkResult struct {
+	Runs []BuildTimings
+}
+
+// Benchmark builds the source module count times into dstPath. Between the builds, the project is Reset, so
+// that each build hashes and compiles everything again. A compile error fails the benchmark.
+func Benchmark(dstPath, srcPath string, opts Options, count int) (BenchmarkResult, error) {
+	if count < 1 {
+		return BenchmarkResult{}, fmt.Errorf("invalid build count: %d", count)
+	}
+
+	prj, err := NewProject(dstPath, srcPath)
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+
+	defer prj.Close()
+
+	res := BenchmarkResult{}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			prj.Reset()
+		}
+
+		if _, err := prj.Build(opts); err != nil {
+			return res, fmt.Errorf("unable to build run %d: %w", i+1, err)
+		}
+
+		res.Runs = append(res.Runs, prj.LastTimings())
+	}
+
+	return res, nil
+}
+
+// Stats summarizes the phase of each run, which is selected by the given func.
+func (r BenchmarkResult) Stats(phase func(t BuildTimings) time.Duration) DurationStats {
+	if len(r.Runs) == 0 {
+		return DurationStats{}
+	}
+
+	durations := make([]time.Duration, 0, len(r.Runs))
+	var sum time.Duration
+	for _, run := range r.Runs {
+		d := phase(run)
+		durations = append(durations, d)
+		sum += d
+	}
+
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+
+	// nearest-rank percentile, which is the maximum for less than 100 runs
+	p99 := (len(durations)*99 + 99) / 100
+
+	return DurationStats{
+		Min:  durations[0],
+		Max:  durations[len(durations)-1],
+		Mean: sum / time.Duration(len(durations)),
+		P99:  durations[p99-1],
+	}
+}
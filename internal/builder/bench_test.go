@@ -0,0 +1,37 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBenchmarkStats(t *testing.T) {
+	res := BenchmarkResult{}
+	for _, ms := range []int{40, 10, 30, 20} {
+		res.Runs = append(res.Runs, BuildTimings{Total: time.Duration(ms) * time.Millisecond})
+	}
+
+	stats := res.Stats(func(t BuildTimings) time.Duration { return t.Total })
+	want := DurationStats{Min: 10 * time.Millisecond, Max: 40 * time.Millisecond, Mean: 25 * time.Millisecond, P99: 40 * time.Millisecond}
+	if stats != want {
+		t.Fatalf("expected %+v but got %+v", want, stats)
+	}
+
+	if (BenchmarkResult{}).Stats(func(t BuildTimings) time.Duration { return t.Total }) != (DurationStats{}) {
+		t.Fatal("expected zero stats without runs")
+	}
+}
@@ -31,6 +31,10 @@ func TestGoBuildWasm(t *testing.T) {
 
 	tmpDir := filepath.Join(os.TempDir(), "gotrino-make")
 	prjDir := "/Users/tschinke/git/github.com/golangee/gotrino-tutorial.git"
+	if _, err := os.Stat(prjDir); err != nil {
+		t.Skip("external project not available, see TestBuildFixture:", err)
+	}
+
 	prj, err := builder.NewProject(tmpDir, prjDir)
 	if err != nil {
 		t.Fatal(err)
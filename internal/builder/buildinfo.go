@@ -1,17 +1,105 @@
 package builder
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golangee/log"
+	"html"
+	htmltemplate "html/template"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 )
 
+const (
+	// DiagnosticCompiler denotes a diagnostic emitted by the go tool chain.
+	DiagnosticCompiler = "compiler"
+	// DiagnosticTemplate denotes a diagnostic emitted while applying a template.
+	DiagnosticTemplate = "template"
+)
+
+var (
+	regexExitStatus = regexp.MustCompile(`^exit status \d+:?\s*`)
+	regexTemplate   = regexp.MustCompile(`template: (\S+?):(\d+)(?::(\d+))?: (.*)$`)
+	regexGoLocation = regexp.MustCompile(`^(\S+?\.\w+):(\d+)(?::(\d+))?: (.*)$`)
+)
+
+// safeTemplateExtensions are the template extensions, which are processed with html/template, if
+// Options.SafeTemplates is enabled.
+var safeTemplateExtensions = []string{".gohtml", ".goxml"}
+
+// templateFuncs are available in all templates.
+var templateFuncs = map[string]interface{}{
+	"humanize": humanize,
+}
+
+// humanize formats a byte count with a binary unit, e.g. 1.5 MiB.
+func humanize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// templateExecutor is the common denominator of text/template and html/template.
+type templateExecutor interface {
+	Execute(wr io.Writer, data interface{}) error
+}
+
+// parseTemplate parses the text either with html/template or text/template.
+func parseTemplate(name, text string, safe bool) (templateExecutor, error) {
+	if safe {
+		return htmltemplate.New(name).Funcs(templateFuncs).Parse(text)
+	}
+
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// templateEngine returns the package name of the engine behind the given template.
+func templateEngine(tpl templateExecutor) string {
+	switch tpl.(type) {
+	case *htmltemplate.Template:
+		return "html/template"
+	case *template.Template:
+		return "text/template"
+	default:
+		return fmt.Sprintf("%T", tpl)
+	}
+}
+
+// useSafeTemplate returns true, if the given file must be processed using html/template.
+func useSafeTemplate(fname string, opts Options) bool {
+	if !opts.SafeTemplates {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(fname))
+	for _, safeExt := range safeTemplateExtensions {
+		if ext == safeExt {
+			return true
+		}
+	}
+
+	return false
+}
+
 // A CompileErr denotes a special build error, which is solely related to applying templates or the go compiler.
 type CompileErr struct {
 	delegate error
@@ -25,28 +113,176 @@ func (b CompileErr) Unwrap() error {
 	return b.delegate
 }
 
+// A WasmTooLargeError is a CompileError which indicates that the compiled wasm binary exceeds the configured
+// size budget.
+type WasmTooLargeError struct {
+	Size   int64 // Size of the compiled wasm file in bytes.
+	Budget int64 // Budget is the maximum allowed size in bytes.
+}
+
+func (e WasmTooLargeError) Error() string {
+	return fmt.Sprintf("wasm binary too large: %d bytes exceeds budget of %d bytes", e.Size, e.Budget)
+}
+
+// An InternalBuildError indicates, that the output of a build is invalid, although the compiler succeeded. In
+// contrast to a CompileErr, it is not caused by the sources of the user.
+type InternalBuildError struct {
+	File   string // File is the invalid output file.
+	Reason string // Reason describes what is wrong with the File.
+	Err    error  // Err is the cause and may be nil.
+}
+
+func (e InternalBuildError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("internal build error: %s: %s: %v", e.File, e.Reason, e.Err)
+	}
+
+	return fmt.Sprintf("internal build error: %s: %s", e.File, e.Reason)
+}
+
+func (e InternalBuildError) Unwrap() error {
+	return e.Err
+}
+
+// A CompilerDiagnostic is a single structured message from the compiler or the template engine.
+type CompilerDiagnostic struct {
+	File    string // File may be empty, if the message has no location.
+	Line    int    // Line is 1-based or 0 if unknown.
+	Col     int    // Col is 1-based or 0 if unknown.
+	Message string // Message may span multiple lines.
+	Kind    string // Kind is either DiagnosticCompiler or DiagnosticTemplate.
+}
+
+// Location returns the file:line:col triple or the empty string.
+func (d CompilerDiagnostic) Location() string {
+	if d.File == "" {
+		return ""
+	}
+
+	loc := d.File + ":" + strconv.Itoa(d.Line)
+	if d.Col > 0 {
+		loc += ":" + strconv.Itoa(d.Col)
+	}
+
+	return loc
+}
+
+// parseCompilerOutput splits the raw output of go build or a template error into structured diagnostics.
+// Indented lines are continuations of the previous message, package headers (# pkg) and exit codes are dropped.
+func parseCompilerOutput(raw string) []CompilerDiagnostic {
+	var res []CompilerDiagnostic
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(res) > 0 {
+			res[len(res)-1].Message += "\n" + strings.TrimSpace(line)
+			continue
+		}
+
+		line = strings.TrimSpace(regexExitStatus.ReplaceAllString(strings.TrimSpace(line), ""))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := regexTemplate.FindStringSubmatch(line); m != nil {
+			res = append(res, newDiagnostic(DiagnosticTemplate, m))
+			continue
+		}
+
+		if m := regexGoLocation.FindStringSubmatch(line); m != nil {
+			res = append(res, newDiagnostic(DiagnosticCompiler, m))
+			continue
+		}
+
+		res = append(res, CompilerDiagnostic{Message: line, Kind: DiagnosticCompiler})
+	}
+
+	return res
+}
+
+// newDiagnostic creates a diagnostic from the sub matches file, line, col and message.
+func newDiagnostic(kind string, m []string) CompilerDiagnostic {
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+
+	return CompilerDiagnostic{
+		File:    m[1],
+		Line:    line,
+		Col:     col,
+		Message: m[4],
+		Kind:    kind,
+	}
+}
+
 // BuildInfo provides some basic information about a gotrino build.
 type BuildInfo struct {
 	// Time of this build.
 	Time time.Time
-	// Version contains a hash or something else which uniquely identifies this build.
+	// Version contains a hash or something else which uniquely identifies this build. After a successful
+	// compilation, it is the hash of the app.wasm, so that equal binaries have equal versions.
 	Version string
+	// SourceVersion is the hash of all sources, which is the Version of builds without app.wasm.
+	SourceVersion string
 	// CompileError is nil or contains a compile error.
 	CompileError error
+	// Diagnostics contains the parsed CompileError.
+	Diagnostics []CompilerDiagnostic
 	// HotReload is true, if the server should be polled at /api/v1/poll/version.
 	HotReload bool
+	// ReloadDelayMs is the time in milliseconds to wait after a version change, before calling location.reload(),
+	// so that the new app.wasm has been written completely.
+	ReloadDelayMs int
 	// Wasm is true, if the web assembly (app.wasm) is available.
 	Wasm bool
+	// WasmSizeBytes is the size of the compiled web assembly (app.wasm) in bytes.
+	WasmSizeBytes int64
+	// TotalStaticBytes is the size of all static files of all modules in bytes. Shadowed files are not counted.
+	// Templates can format it by {{humanize .TotalStaticBytes}}.
+	TotalStaticBytes int64
 	// Commit may be empty, if the project is not contained in a git repository.
 	Commit string
+	// ShortCommit is the abbreviated Commit, which is more readable for labels.
+	ShortCommit string
+	// GitDescribe is the output of git describe, like v1.2.3-5-gabcdef1-dirty or the ShortCommit, if no tags exist.
+	GitDescribe string
 	// Host name.
 	Host string
 	// Compiler denotes the compiler which has created the wasm build.
 	Compiler string
+	// Changes contains the source files of the main module, which have been changed since the last build.
+	Changes []string
+	// BasePath is the URL prefix without a trailing slash, e.g. /app or empty, so that
+	// {{.BasePath}}/wasm_exec.js is always valid.
+	BasePath string
+	// CSSWarnings contains the css classes of .gohtml templates like index.gohtml: bg-rde-500, which are not
+	// defined by any css file. It is only populated, if Options.VerifyCSSClasses is set.
+	CSSWarnings []string
+	// WasmProfile is the profile type of the injected profiler or empty. If set, app.wasm exports the JavaScript
+	// functions startProfile and stopProfile, which should be called when /api/v1/profile/state changes.
+	WasmProfile string
 	// Extra may be nil or injected by user.
 	Extra interface{}
 }
 
+// renderHash returns the hash of all fields, which a template may render. It is false, if the fields cannot be
+// hashed, e.g. because the Extra value cannot be marshalled.
+func (b BuildInfo) renderHash() ([32]byte, bool) {
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return [32]byte{}, false
+	}
+
+	// errors are marshalled as empty objects
+	if b.CompileError != nil {
+		buf = append(buf, b.CompileError.Error()...)
+	}
+
+	return sha256.Sum256(buf), true
+}
+
 // HasError returns true, if something went wrong while building.
 func (b BuildInfo) HasError() bool {
 	return b.CompileError != nil
@@ -54,15 +290,46 @@ func (b BuildInfo) HasError() bool {
 
 // Error returns an html formatted error description. Check HasError before.
 func (b BuildInfo) Error() string {
+	sb := &strings.Builder{}
+	_ = b.RenderError(sb)
+
+	return sb.String()
+}
+
+// ErrorHTML returns the description of Error as trusted html, so that html/template does not escape the
+// markup, e.g. with Options.SafeTemplates. This is safe, because RenderError escapes all messages.
+func (b BuildInfo) ErrorHTML() htmltemplate.HTML {
+	return htmltemplate.HTML(b.Error())
+}
+
+// RenderError writes the html formatted error description of Error into w, without keeping the entire
+// description in memory. All messages and file names are escaped. It returns the first write error.
+func (b BuildInfo) RenderError(w io.Writer) error {
 	str := ""
 	if b.CompileError != nil {
 		str = b.CompileError.Error()
 	}
 
-	sb := &strings.Builder{}
+	sb := &errWriter{w: w}
 	sb.WriteString("<div class=\"h-screen bg-gray-600 p-10\">")
 	sb.WriteString("<div class=\"bg-white max-w-6xl p-1 rounded overflow-hidden shadow-lg dark:bg-gray-800\">\n")
 	sb.WriteString("<p class=\"text-xl text-red-600\">build error</p>")
+	if len(b.Diagnostics) > 0 {
+		for _, d := range b.Diagnostics {
+			sb.WriteString("<p class=\"text-base text-red-600 medium\">")
+			if loc := d.Location(); loc != "" {
+				sb.WriteString("<span class=\"font-bold\">")
+				sb.WriteString(html.EscapeString(loc))
+				sb.WriteString("</span> ")
+			}
+
+			sb.WriteString(strings.ReplaceAll(html.EscapeString(d.Message), "\n", "<br>"))
+			sb.WriteString("</p>\n")
+		}
+
+		str = ""
+	}
+
 	for _, line := range strings.Split(str, "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -73,18 +340,38 @@ func (b BuildInfo) Error() string {
 		} else {
 			sb.WriteString("<p class=\"text-base text-red-600 medium\">")
 		}
-		sb.WriteString(line)
+		sb.WriteString(html.EscapeString(line))
 		sb.WriteString("</p>\n")
 	}
+
+	var sizeErr WasmTooLargeError
+	if errors.As(b.CompileError, &sizeErr) {
+		sb.WriteString(fmt.Sprintf("<p class=\"text-base medium\">wasm size: %d bytes, budget: %d bytes</p>\n", sizeErr.Size, sizeErr.Budget))
+	}
 	sb.WriteString("</div>\n")
 	sb.WriteString("</div>\n")
-	return sb.String()
+	return sb.err
+}
+
+// errWriter remembers the first error and ignores all writes afterwards.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) WriteString(s string) {
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = io.WriteString(e.w, s)
 }
 
 // applyTemplate reads the given file, applies it as a text/template and writes it back again. If file name contains
-// a *.go<ext> pattern, the 'go' part is removed, also like the original file as well. The (new) written file name
-// returned.
-func (b BuildInfo) applyTemplate(fname string) (string, error) {
+// a *.go<ext> pattern, the 'go' part is removed, also like the original file as well, unless keepSource is true.
+// The (new) written file name returned. If safe is true, html/template is used instead, which escapes the injected
+// values.
+func (b BuildInfo) applyTemplate(fname string, safe, keepSource bool) (string, error) {
 	rawText, err := ioutil.ReadFile(fname)
 	if err != nil {
 		return "", fmt.Errorf("unable to read template file: %w", err)
@@ -92,31 +379,35 @@ func (b BuildInfo) applyTemplate(fname string) (string, error) {
 
 	text := string(rawText)
 
-	tpl, err := template.New(fname).Parse(text)
+	tpl, err := parseTemplate(fname, text, safe)
 	if err != nil {
-		return "", fmt.Errorf("unable to parse text template: %w", err)
+		return "", fmt.Errorf("unable to parse template: %w", err)
 	}
 
-	buf := &bytes.Buffer{}
-	if err := tpl.Execute(buf, b); err != nil {
-		return "", fmt.Errorf("unable to execute BuildInfo template: %w", err)
+	if Debug {
+		log.Println(fmt.Sprintf("BuildInfo: using %s for %s", templateEngine(tpl), fname))
 	}
 
-	dstFile := fname
-	myExt := filepath.Ext(fname)
-	if strings.HasPrefix(myExt, ".go") {
-		dstFile = fname[0:len(dstFile)-len(myExt)] + "." + myExt[3:]
-	}
+	dstFile := templateTargetFile(fname)
 
 	if Debug {
 		log.Println(fmt.Sprintf("BuildInfo: wrote template file to: %s", dstFile))
 	}
 
-	if err := ioutil.WriteFile(dstFile, buf.Bytes(), os.ModePerm); err != nil {
-		return "", fmt.Errorf("unable to write target file: %w", err)
+	// execute into a temporary file first, so that a failure neither truncates dstFile nor loses the source.
+	// The output is streamed, so that e.g. a large error description is not buffered entirely.
+	tmpFile := dstFile + ".tmp"
+	if err := executeTemplate(tpl, b, tmpFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return "", err
 	}
 
-	if dstFile != fname {
+	if err := os.Rename(tmpFile, dstFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return "", fmt.Errorf("unable to replace target file: %w", err)
+	}
+
+	if dstFile != fname && !keepSource {
 		if Debug {
 			log.Println(fmt.Sprintf("BuildInfo: remove extra file: %s", fname))
 		}
@@ -128,3 +419,38 @@ func (b BuildInfo) applyTemplate(fname string) (string, error) {
 
 	return dstFile, nil
 }
+
+// executeTemplate applies the BuildInfo and writes the result into the given file.
+func executeTemplate(tpl templateExecutor, b BuildInfo, fname string) error {
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write target file: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	if err := tpl.Execute(w, b); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to execute BuildInfo template: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write target file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write target file: %w", err)
+	}
+
+	return nil
+}
+
+// templateTargetFile returns the file name of the applied template, e.g. index.html for index.gohtml.
+func templateTargetFile(fname string) string {
+	myExt := filepath.Ext(fname)
+	if strings.HasPrefix(myExt, ".go") {
+		return fname[0:len(fname)-len(myExt)] + "." + myExt[3:]
+	}
+
+	return fname
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseCompilerOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []CompilerDiagnostic
+	}{
+		{
+			name: "go build",
+			raw:  "exit status 2: # example.com/app/cmd/wasm\n./main.go:12:5: undefined: foo\ninternal/x.go:3: missing return",
+			want: []CompilerDiagnostic{
+				{File: "./main.go", Line: 12, Col: 5, Message: "undefined: foo", Kind: DiagnosticCompiler},
+				{File: "internal/x.go", Line: 3, Message: "missing return", Kind: DiagnosticCompiler},
+			},
+		},
+		{
+			name: "multi-line",
+			raw:  "# example.com/app\n./main.go:7:9: cannot use x (type int) as type string in return argument\n\thave (int)\n\twant (string)",
+			want: []CompilerDiagnostic{
+				{File: "./main.go", Line: 7, Col: 9, Message: "cannot use x (type int) as type string in return argument\nhave (int)\nwant (string)", Kind: DiagnosticCompiler},
+			},
+		},
+		{
+			name: "cgo",
+			raw:  "# runtime/cgo\ncgo: C compiler \"gcc\" not found: exec: \"gcc\": executable file not found in $PATH\n./c.go:10:3: could not determine kind of name for C.bar",
+			want: []CompilerDiagnostic{
+				{Message: "cgo: C compiler \"gcc\" not found: exec: \"gcc\": executable file not found in $PATH", Kind: DiagnosticCompiler},
+				{File: "./c.go", Line: 10, Col: 3, Message: "could not determine kind of name for C.bar", Kind: DiagnosticCompiler},
+			},
+		},
+		{
+			name: "template",
+			raw:  "unable to execute BuildInfo template: template: /tmp/www/index.gohtml:4:12: executing \"/tmp/www/index.gohtml\" at <.Foo>: can't evaluate field Foo",
+			want: []CompilerDiagnostic{
+				{File: "/tmp/www/index.gohtml", Line: 4, Col: 12, Message: "executing \"/tmp/www/index.gohtml\" at <.Foo>: can't evaluate field Foo", Kind: DiagnosticTemplate},
+			},
+		},
+		{
+			name: "without location",
+			raw:  "exit status 1: go: cannot find main module\nexit status 1",
+			want: []CompilerDiagnostic{
+				{Message: "go: cannot find main module", Kind: DiagnosticCompiler},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCompilerOutput(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCompilerOutput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTemplateKeepsSourceOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "index.gohtml")
+	if err := ioutil.WriteFile(src, []byte("{{.Version}}"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// a non-empty directory cannot be replaced by a file
+	if err := os.MkdirAll(filepath.Join(dir, "index.html", "blocked"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (BuildInfo{Version: "1"}).applyTemplate(src, false, false); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("expected the source template to be kept: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "index.html.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary file to be removed: %v", err)
+	}
+}
+
+// failingWriter accepts limit bytes and fails afterwards.
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, errors.New("disk full")
+	}
+
+	w.limit -= len(p)
+
+	return len(p), nil
+}
+
+func TestRenderError(t *testing.T) {
+	info := BuildInfo{CompileError: errors.New("main.go:1:2: undefined: foo\nexit status 2")}
+
+	sb := &strings.Builder{}
+	if err := info.RenderError(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	if sb.String() != info.Error() || !strings.Contains(sb.String(), "undefined: foo") {
+		t.Fatalf("unexpected error html: %s", sb.String())
+	}
+
+	if err := info.RenderError(&failingWriter{limit: 50}); err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected the write error but got %v", err)
+	}
+}
+
+func TestErrorEscapesHTML(t *testing.T) {
+	raw := "main.go:3:2: package <fakePackage> is not in std\n<script>alert(1)</script> & \"quoted\""
+	info := BuildInfo{CompileError: errors.New(raw)}
+
+	for _, diagnostics := range [][]CompilerDiagnostic{nil, parseCompilerOutput(raw)} {
+		info.Diagnostics = diagnostics
+		html := info.Error()
+		if strings.Contains(html, "<fakePackage>") || strings.Contains(html, "<script>") {
+			t.Fatalf("expected escaped html but got %s", html)
+		}
+
+		if !strings.Contains(html, "&lt;fakePackage&gt;") || !strings.Contains(html, "&amp; &#34;quoted&#34;") {
+			t.Fatalf("expected the escaped message but got %s", html)
+		}
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	}
+
+	for in, want := range tests {
+		if got := humanize(in); got != want {
+			t.Fatalf("%d: expected %s but got %s", in, want, got)
+		}
+	}
+}
+
+func TestApplyTemplateKeepSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "index.gohtml")
+	if err := ioutil.WriteFile(src, []byte("{{.Version}}"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := (BuildInfo{Version: "1"}).applyTemplate(src, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "1" {
+		t.Fatalf("unexpected output: %s", string(buf))
+	}
+
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("expected the source template to be kept: %v", err)
+	}
+}
+
+func TestErrorHTMLSafeTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "index.gohtml")
+	if err := ioutil.WriteFile(src, []byte("{{if .HasError}}{{.ErrorHTML}}{{end}}"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	info := BuildInfo{CompileError: errors.New("main.go:1:2: <script>alert(1)</script>")}
+	if _, err := info.applyTemplate(src, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(buf), "<div") || strings.Contains(string(buf), "<script>") {
+		t.Fatalf("expected the unescaped overlay with an escaped message but got %s", string(buf))
+	}
+}
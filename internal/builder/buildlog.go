@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultBuildLogMaxBytes is the size at which a build log file is rotated, if nothing else has been configured.
+const DefaultBuildLogMaxBytes = 10 * 1024 * 1024
+
+// buildLog appends timestamped lines to a file, which is rotated when it grows too large. A nil buildLog
+// discards everything.
+type buildLog struct {
+	fname    string
+	maxBytes int64
+	size     int64
+	file     *os.File
+	writer   *bufio.Writer
+}
+
+// openBuildLog opens or creates the given log file in append mode.
+func openBuildLog(fname string, maxBytes int64) (*buildLog, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultBuildLogMaxBytes
+	}
+
+	l := &buildLog{fname: fname, maxBytes: maxBytes}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *buildLog) open() error {
+	file, err := os.OpenFile(l.fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open build log: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to stat build log: %w", err)
+	}
+
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = stat.Size()
+
+	return nil
+}
+
+// rotate renames the current file to <fname>.1, replacing any older one, and starts a new file.
+func (l *buildLog) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(l.fname, l.fname+".1"); err != nil {
+		return fmt.Errorf("unable to rotate build log: %w", err)
+	}
+
+	return l.open()
+}
+
+// Println writes each line of msg with a timestamp, the build hash and the phase.
+func (l *buildLog) Println(hash, phase, msg string) {
+	if l == nil {
+		return
+	}
+
+	ts := time.Now().Format(time.RFC3339)
+	for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+		entry := fmt.Sprintf("%s %s %s %s\n", ts, hash, phase, line)
+		if l.size+int64(len(entry)) > l.maxBytes && l.size > 0 {
+			if err := l.rotate(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		}
+
+		n, _ := l.writer.WriteString(entry)
+		l.size += int64(n)
+	}
+}
+
+// Flush writes any buffered lines into the file.
+func (l *buildLog) Flush() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.writer.Flush()
+}
+
+func (l *buildLog) close() error {
+	if err := l.writer.Flush(); err != nil {
+		_ = l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// Close flushes and closes the file.
+func (l *buildLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.close()
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/golangee/log"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gzipSuffix = ".gz"
+	// gzipMinSize is the minimum file size to pre-compress. Smaller files do not benefit from compression.
+	gzipMinSize = 1024
+)
+
+// gzipStatic pre-compresses all files of the workPath, which are larger than gzipMinSize, into <file>.gz.
+// A compressed file is only refreshed, if its source is newer. Compressed files of removed sources are
+// deleted. All compressed files are registered as extraDstFiles, so that sync keeps them.
+func (p *Project) gzipStatic() error {
+	files, err := listAllFiles(p.workPath)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		rel, err := filepath.Rel(p.workPath, file)
+		if err != nil {
+			return fmt.Errorf("unable to relativize file: %w", err)
+		}
+
+		// extraDstFiles are compared with the slash separated hashtree.File names
+		rel = filepath.ToSlash(rel)
+
+		if strings.HasSuffix(file, gzipSuffix) {
+			if err := p.removeOrphanedGzip(file, rel); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		stat, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("unable to stat file: %w", err)
+		}
+
+		if stat.Size() < gzipMinSize {
+			continue
+		}
+
+		gzStat, err := os.Stat(file + gzipSuffix)
+		if err == nil {
+			// do not overwrite a compressed file, which has been provided by the project itself
+			if !p.isExtraDstFile(rel + gzipSuffix) {
+				continue
+			}
+
+			if !stat.ModTime().After(gzStat.ModTime()) {
+				continue
+			}
+		}
+
+		if Debug {
+			log.Println(fmt.Sprintf("gzip %s", file))
+		}
+
+		if err := gzipFile(file, file+gzipSuffix); err != nil {
+			return err
+		}
+
+		if !p.isExtraDstFile(rel + gzipSuffix) {
+			p.extraDstFiles = append(p.extraDstFiles, rel+gzipSuffix)
+		}
+	}
+
+	return nil
+}
+
+// removeOrphanedGzip deletes a compressed file created by gzipStatic, if its source does not exist anymore.
+func (p *Project) removeOrphanedGzip(file, rel string) error {
+	if !p.isExtraDstFile(rel) {
+		return nil
+	}
+
+	if _, err := os.Stat(strings.TrimSuffix(file, gzipSuffix)); err == nil {
+		return nil
+	}
+
+	if Debug {
+		log.Println(fmt.Sprintf("removing orphaned gzip file %s", file))
+	}
+
+	if err := os.RemoveAll(file); err != nil {
+		return fmt.Errorf("unable to remove orphaned gzip file: %w", err)
+	}
+
+	for i, name := range p.extraDstFiles {
+		if name == rel {
+			p.extraDstFiles = append(p.extraDstFiles[:i], p.extraDstFiles[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// isExtraDstFile returns true, if the relative file name is contained in extraDstFiles.
+func (p *Project) isExtraDstFile(rel string) bool {
+	for _, name := range p.extraDstFiles {
+		if name == rel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gzipFile compresses src into dst. The dst file is replaced atomically, so that it is never served partially.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("unable to open file to compress: %w", err)
+	}
+
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("unable to create gzip file: %w", err)
+	}
+
+	w, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		_ = out.Close()
+		return fmt.Errorf("unable to create gzip writer: %w", err)
+	}
+
+	if _, err := io.Copy(w, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("unable to compress file: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("unable to finish gzip file: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("unable to close gzip file: %w", err)
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("unable to replace gzip file: %w", err)
+	}
+
+	return nil
+}
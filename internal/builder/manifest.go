@@ -0,0 +1,162 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"github.com/golangee/log"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestFilename is the name of the optional build manifest in the root of the main module.
+const ManifestFilename = "gotrino.yaml"
+
+// Manifest declares the preferred build options of a module. There is no separate config file format: the
+// yaml keys equal the names of the corresponding command line flags, e.g.
+//
+//	skip-mod-tidy: true
+//	env:
+//	  - GONOSUMDB=*.internal.example.com
+type Manifest struct {
+	GOFlags              string   `yaml:"goflags"`
+	Generate             bool     `yaml:"generate"`
+	GoFmt                bool     `yaml:"gofmt"`
+	SafeTemplates        bool     `yaml:"safe-templates"`
+	GzipStatic           bool     `yaml:"gzip-static"`
+	MaxWasmSize          int64    `yaml:"max-wasm-size"`
+	KeepTemplateSources  bool     `yaml:"keep-template-sources"`
+	FailOnStaticConflict bool     `yaml:"fail-on-static-conflict"`
+	VerifyCSSClasses     bool     `yaml:"verify-css-classes"`
+	ModTidyCheck         bool     `yaml:"mod-tidy-check"`
+	SkipModTidy          bool     `yaml:"skip-mod-tidy"`
+	WasmExecJS           string   `yaml:"wasm-exec-js"` // WasmExecJS is relative to the module root.
+	WasmProfile          string   `yaml:"wasm-profile"`
+	Env                  []string `yaml:"env"`
+}
+
+// ParseManifest parses the yaml of a manifest. Keys, which are not part of the Manifest, are ignored and
+// returned as unknown.
+func ParseManifest(buf []byte) (Manifest, []string, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(buf, &m); err != nil {
+		return m, nil, fmt.Errorf("unable to parse manifest: %w", err)
+	}
+
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(buf, &keys); err != nil {
+		return m, nil, fmt.Errorf("unable to parse manifest: %w", err)
+	}
+
+	known := map[string]bool{}
+	t := reflect.TypeOf(m)
+	for i := 0; i < t.NumField(); i++ {
+		known[t.Field(i).Tag.Get("yaml")] = true
+	}
+
+	var unknown []string
+	for key := range keys {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	return m, unknown, nil
+}
+
+// Apply returns the options, whose zero values have been replaced by the values of the manifest. So options
+// from the command line take precedence, however a flag cannot disable a bool, which the manifest enables.
+// A relative WasmExecJS is resolved against the given module directory.
+func (m Manifest) Apply(opts Options, modDir string) Options {
+	if opts.GOFlags == "" {
+		opts.GOFlags = m.GOFlags
+	}
+
+	opts.GoGenerate = opts.GoGenerate || m.Generate
+	opts.GoFmt = opts.GoFmt || m.GoFmt
+	opts.SafeTemplates = opts.SafeTemplates || m.SafeTemplates
+	opts.GzipStatic = opts.GzipStatic || m.GzipStatic
+	opts.KeepTemplateSources = opts.KeepTemplateSources || m.KeepTemplateSources
+	opts.FailOnStaticConflict = opts.FailOnStaticConflict || m.FailOnStaticConflict
+	opts.VerifyCSSClasses = opts.VerifyCSSClasses || m.VerifyCSSClasses
+	opts.GoModTidyCheck = opts.GoModTidyCheck || m.ModTidyCheck
+	opts.SkipModTidy = opts.SkipModTidy || m.SkipModTidy
+
+	if opts.MaxWasmSizeBytes == 0 {
+		opts.MaxWasmSizeBytes = m.MaxWasmSize
+	}
+
+	if opts.WasmExecJS == "" && m.WasmExecJS != "" {
+		opts.WasmExecJS = m.WasmExecJS
+		if !filepath.IsAbs(opts.WasmExecJS) {
+			opts.WasmExecJS = filepath.Join(modDir, opts.WasmExecJS)
+		}
+	}
+
+	if opts.WasmProfile == "" {
+		opts.WasmProfile = m.WasmProfile
+	}
+
+	if len(opts.ExtraEnv) == 0 {
+		opts.ExtraEnv = m.Env
+	}
+
+	return opts
+}
+
+// loadManifest returns the manifest of the main module or an empty Manifest, if it has none. The file is only
+// parsed again, if it has been modified, so that unknown keys are reported once.
+func (p *Project) loadManifest() (Manifest, error) {
+	fname := filepath.Join(p.srcPath, ManifestFilename)
+	stat, err := os.Stat(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			p.manifest, p.manifestModTime = Manifest{}, time.Time{}
+			return p.manifest, nil
+		}
+
+		return Manifest{}, fmt.Errorf("unable to stat manifest: %w", err)
+	}
+
+	if stat.ModTime().Equal(p.manifestModTime) {
+		return p.manifest, nil
+	}
+
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("unable to read manifest: %w", err)
+	}
+
+	m, unknown, err := ParseManifest(buf)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%s: %w", fname, err)
+	}
+
+	if len(unknown) > 0 {
+		log.Println(fmt.Sprintf("warning: %s contains unknown keys: %s", fname, strings.Join(unknown, ", ")))
+	}
+
+	p.manifest, p.manifestModTime = m, stat.ModTime()
+
+	return m, nil
+}
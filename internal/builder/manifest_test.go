@@ -0,0 +1,59 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	buf := []byte(`
+goflags: -modcacherw
+skip-mod-tidy: true
+max-wasm-size: 1024
+wasm-exec-js: js/wasm_exec.js
+env:
+  - GONOSUMDB=*.example.com
+gzip: true
+`)
+
+	m, unknown, err := ParseManifest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(unknown, ",") != "gzip" {
+		t.Fatalf("expected gzip to be unknown but got %v", unknown)
+	}
+
+	opts := m.Apply(Options{GOFlags: "-mod=vendor"}, "/src")
+	if opts.GOFlags != "-mod=vendor" {
+		t.Fatalf("expected the command line to take precedence but got %s", opts.GOFlags)
+	}
+
+	if !opts.SkipModTidy || opts.MaxWasmSizeBytes != 1024 || len(opts.ExtraEnv) != 1 {
+		t.Fatalf("expected the manifest values but got %+v", opts)
+	}
+
+	if opts.WasmExecJS != filepath.Join("/src", "js", "wasm_exec.js") {
+		t.Fatalf("expected a path relative to the module but got %s", opts.WasmExecJS)
+	}
+
+	if _, _, err := ParseManifest([]byte("skip-mod-tidy: [")); err == nil {
+		t.Fatal("expected invalid yaml to fail")
+	}
+}
@@ -4,22 +4,31 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"github.com/golangee/gotrino-make/internal/css"
 	"github.com/golangee/gotrino-make/internal/git"
 	"github.com/golangee/gotrino-make/internal/gotool"
 	"github.com/golangee/gotrino-make/internal/hashtree"
 	"github.com/golangee/gotrino-make/internal/io"
+	"github.com/golangee/gotrino-make/internal/metrics"
 	"github.com/golangee/log"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	wasmFilename       = "app.wasm"
 	goRootJsBridge     = "misc/wasm/wasm_exec.js"
+	goRootLibJsBridge  = "lib/wasm/wasm_exec.js" // goRootLibJsBridge is the location since Go 1.24.
 	wasmBridgeFilename = "wasm_exec.js"
 	staticFolder       = "static"
+	stagingSuffix      = ".staging"
+	oldSuffix          = ".old"
 )
 
 // Debug is a global flag, which is only used by the command line program to track errors down.
@@ -33,6 +42,71 @@ type Options struct {
 	Extra            interface{}
 	Debug            bool
 	GoGenerate       bool
+	MaxWasmSizeBytes int64         // MaxWasmSizeBytes fails the build, if app.wasm is larger. Zero or less disables the check.
+	SafeTemplates    bool          // SafeTemplates uses html/template instead of text/template for *.gohtml and *.goxml files.
+	WatchDebounce    time.Duration // WatchDebounce is the quiet period after file changes, before a rebuild is triggered.
+	GOOS             string        // GOOS is the target operating system, defaults to js.
+	GOARCH           string        // GOARCH is the target architecture, defaults to wasm.
+	AtomicOutput     bool          // AtomicOutput builds into a staging directory, which replaces the output at once.
+	CleanOnError     bool          // CleanOnError removes the generated files, if the build fails.
+	ExtraEnv         []string      // ExtraEnv contains additional KEY=VALUE environment variables for go mod tidy.
+	BuildLogFile     string        // BuildLogFile is optional and receives the output of all build steps.
+	BuildLogMaxBytes int64         // BuildLogMaxBytes is the size at which the BuildLogFile is rotated.
+	GoModTidyCheck   bool          // GoModTidyCheck fails the first build, if the go.mod or go.sum is not tidy.
+	GzipStatic       bool          // GzipStatic pre-compresses all output files larger than 1 KB into <file>.gz.
+	KeepBuilds       int           // KeepBuilds is the amount of successful build snapshots to keep. 0 keeps none.
+	WasmExecJS       string        // WasmExecJS is an optional custom bridge, which replaces the GOROOT wasm_exec.js.
+	Reproducible     bool          // Reproducible zeroes BuildInfo.Time, so that equal sources result in equal outputs.
+	// FailOnStaticConflict fails the build, if multiple dependencies provide the same static file.
+	FailOnStaticConflict bool
+	// ReloadDelay is the time, the hot reload snippet should wait after a version change before reloading.
+	ReloadDelay time.Duration
+	// BasePath is the URL prefix, under which the output is served, e.g. /app. Empty or / serves from the root.
+	BasePath string
+	// ForceRebuild invokes the compiler, even if the source hash equals the hash of the last build.
+	ForceRebuild bool
+	// GOFlags is passed as GOFLAGS to all go commands, if not empty. See GoEnv.
+	GOFlags string
+	// KeepTemplateSources keeps each template source like index.gohtml next to its rendered index.html.
+	KeepTemplateSources bool
+	// FastHash detects file changes using xxh3 instead of sha256, which is faster but not collision resistant.
+	FastHash bool
+	// HashProgress is optional and notified about each hashed source file. Each module is hashed separately,
+	// so the total restarts for every module.
+	HashProgress hashtree.ProgressFunc
+	// PauseServing is optional and invoked, if the output cannot be replaced atomically. Call resume when done.
+	PauseServing func() (resume func())
+	// DesktopNotify shows a desktop notification after each build of the live builder.
+	DesktopNotify bool
+	// NotifyURL receives a POST with the json result of each build of the live builder, if not empty.
+	NotifyURL string
+	// NotifySecret is sent as bearer token to the NotifyURL, if not empty.
+	NotifySecret string
+	// VerifyCSSClasses reports the css classes used by .gohtml templates, which are not defined by any .css file
+	// of the output, as BuildInfo.CSSWarnings.
+	VerifyCSSClasses bool
+	// SkipModTidy does not invoke go mod tidy, which may need network access. The build relies on the go.mod,
+	// go.sum and a pre-populated module cache, e.g. in air-gapped environments. It is implied by a vendor
+	// directory in the main module, unless TidyVendored is set.
+	SkipModTidy bool
+	// TidyVendored invokes go mod tidy, even if the main module contains a vendor directory.
+	TidyVendored bool
+	// WasmProfile injects a heap profiler into app.wasm, if set to mem. Empty disables it. The wasm runtime cannot
+	// record cpu profiles.
+	// See gotool.BuildWasmWithProfile.
+	WasmProfile string
+	// GoFmt formats the packages of the main module with gofmt -w before each build, e.g. to format generated code
+	// or files saved during serve. See gotool.Fmt.
+	GoFmt bool
+}
+
+// GoEnv returns the ExtraEnv for all go commands, completed by the GOFlags, which win.
+func (o Options) GoEnv() []string {
+	if o.GOFlags == "" {
+		return o.ExtraEnv
+	}
+
+	return append(append([]string{}, o.ExtraEnv...), "GOFLAGS="+o.GOFlags)
 }
 
 // A Part of a Project.
@@ -44,7 +118,7 @@ type Part struct {
 // refresh reads the src it represents the current state of the filesystem.
 // If the force flag is true, the entire directory content is hashed again, instead of using the ModTime as
 // a delta indicator. The directory is mod.Dir+static
-func (p *Part) refresh(force bool, subDir string) error {
+func (p *Part) refresh(force bool, subDir string, newHasher hashtree.HasherFactory, progress hashtree.ProgressFunc) error {
 	exists := true
 	dir := filepath.Join(p.mod.Dir, subDir)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -60,7 +134,7 @@ func (p *Part) refresh(force bool, subDir string) error {
 		return nil
 	}
 
-	if err := hashtree.ReadDir(dir, p.src); err != nil {
+	if err := hashtree.ReadDirWithProgress(dir, p.src, true, newHasher, progress); err != nil {
 		return fmt.Errorf("unable to hash src: %w", err)
 	}
 
@@ -74,57 +148,311 @@ type Project struct {
 	mods          []*Part // modules contains at least 1 module. The first module is always the main module.
 	dst           *hashtree.Node
 	dstPath       string   // the actual target directory to merge everything into.
-	extraDstFiles []string // absolute file names in dstPath which must/need not to be deleted.
+	workPath      string   // workPath is either the dstPath or its staging directory, if building atomically.
+	extraDstFiles []string // relative file names in dstPath which must/need not to be deleted.
 	lastBuildHash [32]byte
+	lastVersion   string                // lastVersion is the BuildInfo.Version of the last successful build.
+	generateBase  *hashtree.Node        // generateBase is a snapshot of the main source tree after the last go generate.
+	buildLog      *buildLog             // buildLog is nil, if no Options.BuildLogFile has been set.
+	logHash       string                // logHash is the hash of the current build for the buildLog.
+	tidyChecked   bool                  // tidyChecked is true, after the GoModTidyCheck has been passed.
+	tidySkipped   bool                  // tidySkipped is true, after the skipped go mod tidy has been logged.
+	wasmExecJS    string                // wasmExecJS is the custom bridge file, which has been copied last.
+	fastHash      bool                  // fastHash is true, if all trees have been hashed with xxh3.
+	hashProgress  hashtree.ProgressFunc // hashProgress is the Options.HashProgress of the current build.
+	// manifest is the last parsed gotrino.yaml of the main module and manifestModTime its ModTime.
+	manifest        Manifest
+	manifestModTime time.Time
+	// modFilesHash is the hash of go.mod and go.sum after the last go mod tidy. Tidy is skipped while unchanged.
+	modFilesHash [32]byte
+	// toolchainFingerprint is the fingerprintToolchain of the last build, which is mixed into the srcHash.
+	toolchainFingerprint [32]byte
+	// replaceBases maps the directory of each locally replaced module to its file tree after the last go generate.
+	replaceBases map[string]*hashtree.Node
+	// templateHashCache maps the template file name relative to the workPath to the hash of its source and the
+	// BuildInfo at the last application.
+	templateHashCache map[string][32]byte
+	// templateOutputs maps each rendered file, which is kept by Options.KeepTemplateSources, to its template
+	// source. Both are relative to the workPath.
+	templateOutputs map[string]string
+	timings         BuildTimings // timings of the last Build.
+	// skipped is true, if the last Build has been skipped due to unchanged sources.
+	skipped bool
+	// stats accumulates the results of all Builds. It is guarded by statsLock, because it is read concurrently.
+	stats     buildStats
+	statsLock sync.Mutex
+}
+
+// BuildTimings contains the durations of the phases of a Build. A phase which has been skipped is zero.
+type BuildTimings struct {
+	Total    time.Duration // Total is the wall-clock time of the entire Build.
+	Hash     time.Duration // Hash is the time to hash the source files of all modules.
+	Compile  time.Duration // Compile is the time of the go build of the wasm module.
+	Template time.Duration // Template is the time to apply all templates.
+}
+
+// BuildStats summarizes all Builds of a Project since its creation. Builds, which have been skipped due to
+// unchanged sources, are only counted as Skipped, so that the durations are wall-clock times of entire Builds,
+// which actually compiled or failed.
+type BuildStats struct {
+	TotalBuilds   int   `json:"totalBuilds"`
+	Succeeded     int   `json:"succeeded"`
+	Failed        int   `json:"failed"`
+	Skipped       int   `json:"skipped"`
+	AvgDurationMs int64 `json:"avgDurationMs"`
+	MinDurationMs int64 `json:"minDurationMs"`
+	MaxDurationMs int64 `json:"maxDurationMs"`
+}
+
+// buildStats accumulates the results of Builds.
+type buildStats struct {
+	succeeded, failed, skipped int
+	sum, min, max              time.Duration
+}
+
+// add counts a Build of the given duration.
+func (s *buildStats) add(d time.Duration, err error) {
+	if err != nil {
+		s.failed++
+	} else {
+		s.succeeded++
+	}
+
+	if s.succeeded+s.failed == 1 || d < s.min {
+		s.min = d
+	}
+
+	if d > s.max {
+		s.max = d
+	}
+
+	s.sum += d
+}
+
+// summary returns the BuildStats of all counted Builds.
+func (s buildStats) summary() BuildStats {
+	total := s.succeeded + s.failed
+	if total == 0 {
+		return BuildStats{Skipped: s.skipped}
+	}
+
+	return BuildStats{
+		TotalBuilds:   total,
+		Succeeded:     s.succeeded,
+		Failed:        s.failed,
+		Skipped:       s.skipped,
+		AvgDurationMs: (s.sum / time.Duration(total)).Milliseconds(),
+		MinDurationMs: s.min.Milliseconds(),
+		MaxDurationMs: s.max.Milliseconds(),
+	}
 }
 
 // NewProject allocates a new project and setups one-time things.
 func NewProject(dstPath, srcPath string) (*Project, error) {
 	p := &Project{
-		srcPath: srcPath,
-		dstPath: dstPath,
+		srcPath:  srcPath,
+		dstPath:  dstPath,
+		workPath: dstPath,
 	}
 
-	if err := p.copyWasmBridge(); err != nil {
+	if err := p.copyWasmBridge(""); err != nil {
 		return nil, fmt.Errorf("unable to provide the current Go WASM bridge: %w", err)
 	}
 
+	fingerprint, err := fingerprintToolchain("")
+	if err != nil {
+		return nil, err
+	}
+
+	p.toolchainFingerprint = fingerprint
+
 	return p, nil
 }
 
-func (p *Project) copyWasmBridge() error {
+// copyWasmBridge provides the wasm_exec.js from GOROOT or the given custom bridge file, if it is readable.
+func (p *Project) copyWasmBridge(customBridge string) error {
 	if err := os.MkdirAll(p.dstPath, os.ModePerm); err != nil {
 		return fmt.Errorf("unable to create build directory: %s: %w", p.dstPath, err)
 	}
 
-	goRoot, err := gotool.Env("GOROOT")
-	if err != nil || goRoot == "" {
-		return fmt.Errorf("unable to determine GOROOT: %w", err)
+	bridge := ""
+	if customBridge != "" {
+		if f, err := os.Open(customBridge); err != nil {
+			log.Println(fmt.Sprintf("warning: custom wasm bridge is not readable, using GOROOT version: %v", err))
+		} else {
+			_ = f.Close()
+			bridge = customBridge
+		}
 	}
 
+	if bridge == "" {
+		goRoot, err := gotool.Env("GOROOT")
+		if err != nil || goRoot == "" {
+			return fmt.Errorf("unable to determine GOROOT: %w", err)
+		}
+
+		bridge = GoRootBridge(goRoot)
+	} else if bridge != p.wasmExecJS {
+		warnOutdatedBridge(bridge)
+	}
+
+	p.wasmExecJS = customBridge
+
 	wasmDstFile := filepath.Join(p.dstPath, wasmBridgeFilename)
-	if err := io.CopyFile(wasmDstFile, filepath.Join(goRoot, goRootJsBridge)); err != nil {
+	if err := io.CopyFile(wasmDstFile, bridge); err != nil {
 		return fmt.Errorf("unable to provide wasm-js-bridge: %w", err)
 	}
 
-	p.extraDstFiles = append(p.extraDstFiles, wasmDstFile)
+	for _, file := range p.extraDstFiles {
+		if file == wasmBridgeFilename {
+			return nil
+		}
+	}
+
+	p.extraDstFiles = append(p.extraDstFiles, wasmBridgeFilename)
 
 	return nil
 }
 
-// loadMods refreshes the modules. It tries to avoid resetting modules, to keep their state in-memory and allow delta
-// updates.
-func (p *Project) loadMods() error {
-	str, err := gotool.ModTidy(p.srcPath) // otherwise the Dir folders may be empty, because no sources have been loaded
+// bridgeVersionRegex matches a go version like go1.21.3 in the header comment of a wasm bridge.
+var bridgeVersionRegex = regexp.MustCompile(`go\d+\.\d+(\.\d+)?`)
+
+// bridgeHeaderLines is the amount of lines, which are inspected for a version comment.
+const bridgeHeaderLines = 10
+
+// warnOutdatedBridge logs a warning, if the header comment of the given wasm bridge declares a go version,
+// which is older than the installed go version. Bridges without such a comment are not checked.
+func warnOutdatedBridge(fname string) {
+	buf, err := ioutil.ReadFile(fname)
 	if err != nil {
-		return fmt.Errorf("unable to go mod tidy: %w", err)
+		return
+	}
+
+	lines := strings.SplitN(string(buf), "\n", bridgeHeaderLines+1)
+	if len(lines) > bridgeHeaderLines {
+		lines = lines[:bridgeHeaderLines]
+	}
+
+	bridgeVersion := bridgeVersionRegex.FindString(strings.Join(lines, "\n"))
+	if bridgeVersion == "" {
+		if Debug {
+			log.Println(fmt.Sprintf("wasm bridge %s declares no go version", fname))
+		}
+
+		return
+	}
+
+	goVersion, err := gotool.Version()
+	if err != nil {
+		return
+	}
+
+	if versionLess(bridgeVersion, goVersion) {
+		log.Println(fmt.Sprintf("warning: the wasm bridge %s has been made for %s but %s is installed", fname, bridgeVersion, goVersion))
+	}
+}
+
+// versionLess returns true, if the go version a is older than b. Unparseable versions are never less.
+func versionLess(a, b string) bool {
+	aMajor, aMinor, aPatch, err := gotool.ParseVersion(a)
+	if err != nil {
+		return false
+	}
+
+	bMajor, bMinor, bPatch, err := gotool.ParseVersion(b)
+	if err != nil {
+		return false
+	}
+
+	if aMajor != bMajor {
+		return aMajor < bMajor
+	}
+
+	if aMinor != bMinor {
+		return aMinor < bMinor
+	}
+
+	return aPatch < bPatch
+}
+
+// CleanGenerated removes all generated files, like the wasm binary and its bridge, from the output directory,
+// so that no stale build is served. Static assets are kept. The next Build provides the files again.
+func (p *Project) CleanGenerated() error {
+	files := append([]string{wasmFilename}, p.extraDstFiles...)
+	for _, file := range files {
+		fname := filepath.Join(p.dstPath, file)
+		if Debug {
+			log.Println(fmt.Sprintf("removing generated file %s", fname))
+		}
+
+		if err := os.RemoveAll(fname); err != nil {
+			return fmt.Errorf("unable to remove generated file: %w", err)
+		}
+	}
+
+	// enforce a rebuild
+	for i := range p.lastBuildHash {
+		p.lastBuildHash[i] = 0
+	}
+
+	return nil
+}
+
+// ForceRebuild forgets the hash of the last build, so that the next Build invokes the compiler, even if no
+// source has been changed. In contrast to Reset, all file hashes are kept.
+func (p *Project) ForceRebuild() {
+	for i := range p.lastBuildHash {
+		p.lastBuildHash[i] = 0
+	}
+}
+
+// Reset clears all in-memory state, so that the next Build performs a full rebuild, just as if the
+// process has been restarted.
+func (p *Project) Reset() {
+	for i := range p.lastBuildHash {
+		p.lastBuildHash[i] = 0
+	}
+
+	for _, mod := range p.mods {
+		mod.src = nil
 	}
 
+	if p.main != nil {
+		p.main.src = nil
+	}
+
+	p.mods = nil
+	p.dst = nil
+	p.generateBase = nil
+	p.replaceBases = nil
+	p.tidyChecked = false
+	p.templateHashCache = nil
+	p.modFilesHash = [32]byte{}
+
 	if Debug {
-		log.Println(str)
+		log.Println("project has been reset")
+	}
+}
+
+// loadMods refreshes the modules. It tries to avoid resetting modules, to keep their state in-memory and allow delta
+// updates.
+func (p *Project) loadMods(opts Options) error {
+	vendored := !opts.TidyVendored && isVendored(p.srcPath)
+	if opts.SkipModTidy || vendored {
+		if !p.tidySkipped {
+			if opts.SkipModTidy {
+				log.Println("go mod tidy is skipped, the build relies on the existing go.mod, go.sum and module cache")
+			} else {
+				log.Println("go mod tidy is skipped, because the module is vendored. Use -skip-mod-tidy=false to tidy anyway")
+			}
+
+			p.tidySkipped = true
+		}
+	} else if err := p.tidy(opts); err != nil {
+		return err
 	}
 
-	mods, err := gotool.ModList(p.srcPath)
+	mods, err := gotool.ModList(p.srcPath, opts.GoEnv()...)
 	if err != nil {
 		return fmt.Errorf("unable to list modules: %w", err)
 	}
@@ -133,6 +461,13 @@ func (p *Project) loadMods() error {
 		return fmt.Errorf("no main module found: %s", p.srcPath)
 	}
 
+	// the order of go list may differ between go versions, so sort the dependencies to get a deterministic
+	// srcHash and static file precedence. The main module stays first.
+	deps := mods[1:]
+	sort.SliceStable(deps, func(i, j int) bool {
+		return deps[i].Path < deps[j].Path
+	})
+
 	rebuild := false
 
 	if len(mods) != len(p.mods) {
@@ -167,16 +502,29 @@ func (p *Project) loadMods() error {
 	return nil
 }
 
+// ReplaceDirs returns the local directories of all modules, which are replaced by a go.mod replace directive.
+// The result is only available after the first Build.
+func (p *Project) ReplaceDirs() []string {
+	var res []string
+	for _, mod := range p.mods {
+		if mod.mod.Replace.Dir != "" {
+			res = append(res, mod.mod.Replace.Dir)
+		}
+	}
+
+	return res
+}
+
 // refresh syncs all internal hashtree.Node roots to be equal to the filesystem (which may race logically). Force
 // will calculates all hashes, instead of re-using already calculated ones.
 func (p *Project) refresh(force bool) error {
 	for _, mod := range p.mods {
-		if err := mod.refresh(force, staticFolder); err != nil {
+		if err := mod.refresh(force, staticFolder, p.newHasher(), p.hashProgress); err != nil {
 			return fmt.Errorf("unable to refresh module: %w", err)
 		}
 	}
 
-	if err := p.main.refresh(force, ""); err != nil {
+	if err := p.main.refresh(force, "", p.newHasher(), p.hashProgress); err != nil {
 		return fmt.Errorf("unable to refresh main root: %w", err)
 	}
 
@@ -185,13 +533,110 @@ func (p *Project) refresh(force bool) error {
 		p.dst.Mode = os.ModeDir
 	}
 
-	if err := hashtree.ReadDir(p.dstPath, p.dst); err != nil {
+	if err := hashtree.ReadDir(p.workPath, p.dst, false, p.newHasher()); err != nil {
 		return fmt.Errorf("unable to hash dst: %w", err)
 	}
 
 	return nil
 }
 
+// A staticConflict describes a static file, which is provided by multiple dependency modules.
+type staticConflict struct {
+	Filename string          // Filename is relative to the static folder.
+	Used     gotool.Module   // Used is the module whose file is copied.
+	Shadowed []gotool.Module // Shadowed are the modules whose files are ignored.
+}
+
+// staticConflicts returns all regular files, which are provided by more than one dependency module, sorted by
+// name. Just like in sync, the module with the lowest index wins. The main module is not inspected, because
+// overriding a dependency file from the main module is intentional.
+func (p *Project) staticConflicts() []staticConflict {
+	providers := map[string][]int{}
+	for i := 1; i < len(p.mods); i++ {
+		if p.mods[i].src == nil {
+			continue
+		}
+
+		for _, file := range p.mods[i].src.Flatten("") {
+			if file.Node.Mode.IsRegular() {
+				providers[file.Filename] = append(providers[file.Filename], i)
+			}
+		}
+	}
+
+	var res []staticConflict
+	for fname, indices := range providers {
+		if len(indices) < 2 {
+			continue
+		}
+
+		conflict := staticConflict{Filename: fname, Used: p.mods[indices[0]].mod}
+		for _, idx := range indices[1:] {
+			conflict.Shadowed = append(conflict.Shadowed, p.mods[idx].mod)
+		}
+
+		res = append(res, conflict)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Filename < res[j].Filename
+	})
+
+	return res
+}
+
+// staticBytes returns the size of all static files of all modules. Like in the output, a file of a module
+// shadows the files with the same name of all following modules.
+func (p *Project) staticBytes() int64 {
+	seen := map[string]bool{}
+	var total int64
+	for _, mod := range p.mods {
+		if mod.src == nil {
+			continue
+		}
+
+		for _, file := range mod.src.Flatten("") {
+			if !file.Node.Mode.IsRegular() || seen[file.Filename] {
+				continue
+			}
+
+			seen[file.Filename] = true
+			total += file.Node.Size
+		}
+	}
+
+	return total
+}
+
+// checkStaticConflicts logs a warning for each static file conflict between dependencies and returns an error,
+// if fail is true and at least one conflict has been found.
+func (p *Project) checkStaticConflicts(fail bool) error {
+	conflicts := p.staticConflicts()
+	for _, conflict := range conflicts {
+		var shadowed []string
+		for _, mod := range conflict.Shadowed {
+			shadowed = append(shadowed, moduleName(mod))
+		}
+
+		log.Println(fmt.Sprintf("warning: static file %s is provided by multiple modules, using %s, shadowing %s", conflict.Filename, moduleName(conflict.Used), strings.Join(shadowed, ", ")))
+	}
+
+	if fail && len(conflicts) > 0 {
+		return fmt.Errorf("%d static files are provided by multiple modules", len(conflicts))
+	}
+
+	return nil
+}
+
+// moduleName returns path@version of the given module.
+func moduleName(mod gotool.Module) string {
+	if mod.Version == "" {
+		return mod.Path
+	}
+
+	return mod.Path + "@" + mod.Version
+}
+
 // sync writes only different files from src to dst based on the current meta data.
 // Actually we assemble a virtual overlay, so that we can determine which files are shadowed and need to be actually
 // copied and written over (only once) and which files are extra.
@@ -205,14 +650,14 @@ func (p *Project) sync() error {
 		srcTree = hashtree.PutTop(srcTree, mod.src.Flatten(filepath.Join(mod.mod.Dir, staticFolder)))
 	}
 
-	dstTree := p.dst.Flatten(p.dstPath)
+	dstTree := p.dst.Flatten(p.workPath)
 
 	// copy only files which are different in content or do not exist at all
 	for _, file := range srcTree {
 		idx := hashtree.FindFile(dstTree, file.Filename)
 		if idx == -1 || file.Node.Hash != dstTree[idx].Node.Hash {
 			from := filepath.Join(file.Prefix, file.Filename)
-			to := filepath.Join(p.dstPath, file.Filename)
+			to := filepath.Join(p.workPath, file.Filename)
 
 			if file.Node.Mode.IsDir() {
 				if Debug {
@@ -252,7 +697,7 @@ NextFile:
 			to := filepath.Join(file.Prefix, file.Filename)
 
 			for _, dstFile := range p.extraDstFiles {
-				if to == dstFile {
+				if file.Filename == dstFile {
 					continue NextFile
 				}
 			}
@@ -270,134 +715,627 @@ NextFile:
 	return nil
 }
 
-// srcHash calculates an uber hash from all source modules.
-func (p *Project) srcHash() [32]byte {
-	hasher := sha256.New()
-	for _, mod := range p.mods {
-		hasher.Write(mod.src.Hash[:])
-	}
+// generate invokes go generate for all packages of the main module, which have changed since the last invocation.
+// The very first invocation generates all packages.
+func (p *Project) generate(force bool, env []string) error {
+	var genPrints string
+	var err error
 
-	hasher.Write(p.main.src.Hash[:])
+	if p.generateBase == nil || force {
+		if Debug {
+			log.Println("invoking go generate ./...")
+		}
 
-	var r [32]byte
-	tmp := hasher.Sum(nil)
-	copy(r[:], tmp)
+		genPrints, err = gotool.Generate(p.srcPath, env...)
+	} else if pkgs := p.changedPackages(); len(pkgs) > 0 {
+		if Debug {
+			log.Println(fmt.Sprintf("invoking go generate %s", strings.Join(pkgs, " ")))
+		}
 
-	return r
-}
+		genPrints, err = gotool.GenerateSelective(p.srcPath, pkgs, env...)
+	} else if Debug {
+		// the replaced modules may have been changed anyway
+		log.Println("no packages of the main module changed, go generate not required")
+	}
 
-// Build syncs the file tree of all modules into the build destination directory and compiles the web assembly.
-// Returns the unique hash of the last build.
-func (p *Project) Build(opts Options) ([32]byte, error) {
-	start := time.Now()
-	defer func() {
-		log.Println(fmt.Sprintf("build duration: %v", time.Now().Sub(start)))
-	}()
+	if err != nil {
+		return fmt.Errorf("failed to go generate: %w", err)
+	}
 
-	if err := os.MkdirAll(p.dstPath, os.ModePerm); err != nil {
-		return p.lastBuildHash, fmt.Errorf("unable to create build directory: %s: %w", p.dstPath, err)
+	if Debug {
+		log.Println(genPrints)
 	}
 
-	if err := p.loadMods(); err != nil {
-		return p.lastBuildHash, fmt.Errorf("unable to load modules: %w", err)
+	if genPrints != "" {
+		p.buildLog.Println(p.logHash, "generate", genPrints)
 	}
 
-	if err := p.refresh(opts.Force); err != nil {
-		return p.lastBuildHash, fmt.Errorf("unable to refresh file hashes: %w", err)
+	if err := p.generateReplaced(force, env); err != nil {
+		return err
 	}
 
-	// only compare originally synced hashes, to avoid any other copy work, which just creates invalid
-	// intermediate builder states
-	uberHash := p.srcHash()
-	if uberHash == p.lastBuildHash {
-		if Debug {
-			log.Println(fmt.Sprintf("hash unchanged, no build required: %s", hex.EncodeToString(uberHash[:])))
-		}
+	// need to refresh again
+	if err := p.refresh(force); err != nil {
+		return fmt.Errorf("unable to refresh file hashes: %w", err)
+	}
 
-		return p.lastBuildHash, nil
+	p.generateBase = p.main.src.Clone()
+
+	return nil
+}
+
+// logFormat reports the result of gotool.Fmt in the main module. Failures, like syntax errors, do not fail the
+// build, because the compiler reports them anyway, but they are written into the build log.
+func (p *Project) logFormat(files []string, err error) {
+	if len(files) > 0 {
+		log.Println(fmt.Sprintf("formatted %s", strings.Join(files, ", ")))
+		p.buildLog.Println(p.logHash, "gofmt", strings.Join(files, " "))
 	}
 
-	if opts.GoGenerate {
-		if Debug {
-			log.Println("invoking go generate ./...")
+	if err != nil {
+		log.Println(fmt.Sprintf("unable to format sources: %v", err))
+		p.buildLog.Println(p.logHash, "gofmt", err.Error())
+	}
+}
+
+// generateReplaced invokes go generate in all locally replaced modules, which contain files that have been changed
+// since the last invocation. The very first invocation generates all of them. Other dependencies reside read-only
+// in the module cache and are never generated.
+func (p *Project) generateReplaced(force bool, env []string) error {
+	if force || p.replaceBases == nil {
+		p.replaceBases = map[string]*hashtree.Node{}
+	}
+
+	var mods []gotool.Module
+	var changed []string
+	trees := map[string]*hashtree.Node{}
+	for _, part := range p.mods {
+		if part.mod.Main || part.mod.Replace.Dir == "" || trees[part.mod.Dir] != nil {
+			continue
 		}
 
-		genPrints, err := gotool.Generate(p.srcPath)
+		base := p.replaceBases[part.mod.Dir]
+		tree, err := p.hashModule(part.mod.Dir, base)
 		if err != nil {
-			return p.lastBuildHash, fmt.Errorf("failed to go generate: %w", err)
+			return err
 		}
 
-		if Debug {
-			log.Println(genPrints)
+		mods = append(mods, part.mod)
+		trees[part.mod.Dir] = tree
+
+		if base == nil {
+			changed = append(changed, part.mod.Dir)
+			continue
 		}
 
-		// need to refresh again
-		if err := p.refresh(opts.Force); err != nil {
-			return p.lastBuildHash, fmt.Errorf("unable to refresh file hashes: %w", err)
+		for _, file := range hashtree.Diff(base.Flatten(""), tree.Flatten("")) {
+			changed = append(changed, filepath.Join(part.mod.Dir, file))
 		}
 	}
 
-	// reset our last build hash, otherwise we may get weired build/bug/revert/non-build inconsistencies
-	for i := range p.lastBuildHash {
-		p.lastBuildHash[i] = 0
-	}
+	if len(changed) == 0 {
+		if Debug && len(mods) > 0 {
+			log.Println("no replaced modules changed, go generate not required")
+		}
 
-	if Debug {
-		log.Println(fmt.Sprintf("build hash changed, old: %s new: %s", hex.EncodeToString(p.lastBuildHash[:]), hex.EncodeToString(uberHash[:])))
+		return nil
 	}
 
-	// copy all original stuff over, sync also deletes generated extra files like wasm and templates
-	if err := p.sync(); err != nil {
-		return p.lastBuildHash, fmt.Errorf("cannot sync file trees: %w", err)
+	if err := gotool.GenerateModules(mods, changed, env...); err != nil {
+		return fmt.Errorf("failed to go generate: %w", err)
 	}
 
-	// try to actually build, every other error until now was critical
-	buildInfo := BuildInfo{
-		Time:      time.Now(),
-		Version:   hex.EncodeToString(uberHash[:]),
-		HotReload: opts.HotReload,
-		Extra:     opts.Extra,
-	}
+	// the generated files must not trigger another generation
+	for dir, tree := range trees {
+		tree, err := p.hashModule(dir, tree)
+		if err != nil {
+			return err
+		}
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Println("unable to read hostname", err)
+		p.replaceBases[dir] = tree
 	}
 
-	buildInfo.Host = hostname
+	return nil
+}
 
-	gitCommit, err := git.Head(p.srcPath)
-	if err != nil {
-		log.Println("unable to read git head", err)
+// hashModule returns the file tree of the entire module directory. If a former tree is given, it is not modified,
+// but the hashes of its unchanged files are reused.
+func (p *Project) hashModule(dir string, former *hashtree.Node) (*hashtree.Node, error) {
+	tree := hashtree.NewNode()
+	tree.Mode = os.ModeDir
+	if former != nil {
+		tree = former.Clone()
 	}
 
-	buildInfo.Commit = gitCommit
-
-	goVersion, err := gotool.Version()
-	if err != nil {
-		log.Println("unable to get go compiler version", err)
+	if err := hashtree.ReadDir(dir, tree, true, p.newHasher()); err != nil {
+		return nil, fmt.Errorf("unable to hash replaced module: %w", err)
 	}
 
-	buildInfo.Compiler = goVersion
+	return tree, nil
+}
 
-	if err := gotool.BuildWasm(p.mods[0].mod, filepath.Join(p.dstPath, wasmFilename)); err != nil {
-		buildInfo.CompileError = err
-		if Debug {
-			log.Println("wasm build failed", err)
+// changedPackages returns the import paths of all packages of the main module, which contain files that have been
+// changed since the last go generate.
+func (p *Project) changedPackages() []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, file := range hashtree.Diff(p.generateBase.Flatten(""), p.main.src.Flatten("")) {
+		dir := filepath.Dir(file)
+		pkg := p.main.mod.Path
+		if dir != "." {
+			pkg += "/" + filepath.ToSlash(dir)
 		}
-	} else {
-		buildInfo.Wasm = true
-		if Debug {
-			log.Println("wasm build successful")
+
+		if seen[pkg] {
+			continue
+		}
+
+		seen[pkg] = true
+
+		// removed packages cannot be generated anymore
+		if goFiles, _ := filepath.Glob(filepath.Join(p.main.mod.Dir, dir, "*.go")); len(goFiles) == 0 {
+			continue
+		}
+
+		res = append(res, pkg)
+	}
+
+	return res
+}
+
+// newHasher returns the factory, which has been used for all trees of the project.
+func (p *Project) newHasher() hashtree.HasherFactory {
+	if p.fastHash {
+		return hashtree.XXH3Factory
+	}
+
+	return hashtree.SHA256Factory
+}
+
+// tidy invokes go mod tidy, unless go.mod and go.sum are unchanged since the last invocation.
+func (p *Project) tidy(opts Options) error {
+	before, err := hashModFiles(p.srcPath, p.newHasher())
+	if err != nil {
+		return err
+	}
+
+	if before == p.modFilesHash {
+		if Debug {
+			log.Println("go.mod and go.sum are unchanged, skipping go mod tidy")
+		}
+
+		return nil
+	}
+
+	str, err := gotool.ModTidy(p.srcPath, opts.GoEnv()...) // otherwise the Dir folders may be empty, because no sources have been loaded
+	if err != nil {
+		return fmt.Errorf("unable to go mod tidy: %w", err)
+	}
+
+	if Debug {
+		log.Println(str)
+	}
+
+	if str != "" {
+		p.buildLog.Println(p.logHash, "tidy", str)
+	}
+
+	// tidy may have rewritten the files itself
+	after, err := hashModFiles(p.srcPath, p.newHasher())
+	if err != nil {
+		return err
+	}
+
+	p.modFilesHash = after
+
+	return nil
+}
+
+// hashModFiles returns a combined hash of the go.mod and go.sum in the given module directory. A missing go.sum
+// is treated as empty.
+func hashModFiles(modDir string, newHasher hashtree.HasherFactory) ([32]byte, error) {
+	var r [32]byte
+	modHash, err := hashtree.Read(filepath.Join(modDir, "go.mod"), newHasher)
+	if err != nil {
+		return r, fmt.Errorf("unable to hash go.mod: %w", err)
+	}
+
+	sumHash, err := hashtree.Read(filepath.Join(modDir, "go.sum"), newHasher)
+	if err != nil && !os.IsNotExist(err) {
+		return r, fmt.Errorf("unable to hash go.sum: %w", err)
+	}
+
+	hasher := sha256.New()
+	hasher.Write(modHash[:])
+	hasher.Write(sumHash[:])
+	copy(r[:], hasher.Sum(nil))
+
+	return r, nil
+}
+
+// fingerprintToolchain hashes the go version, the GOROOT and the wasm bridge, which is either the readable custom
+// bridge or the one of the GOROOT. An upgraded toolchain therefore invalidates the last build, even if no source
+// has been changed.
+func fingerprintToolchain(customBridge string) ([32]byte, error) {
+	var r [32]byte
+	version, err := gotool.Version()
+	if err != nil {
+		return r, err
+	}
+
+	goRoot, err := gotool.Env("GOROOT")
+	if err != nil {
+		return r, fmt.Errorf("unable to determine GOROOT: %w", err)
+	}
+
+	bridge := GoRootBridge(goRoot)
+	if customBridge != "" {
+		if _, err := os.Stat(customBridge); err == nil {
+			bridge = customBridge
+		}
+	}
+
+	// a missing bridge is reported by copyWasmBridge
+	bridgeHash, _ := hashtree.Read(bridge, nil)
+
+	hasher := sha256.New()
+	hasher.Write([]byte(version))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(goRoot))
+	hasher.Write(bridgeHash[:])
+	copy(r[:], hasher.Sum(nil))
+
+	return r, nil
+}
+
+// GoRootBridge returns the wasm_exec.js of the given GOROOT, which has been moved from misc/wasm to lib/wasm.
+func GoRootBridge(goRoot string) string {
+	bridge := filepath.Join(goRoot, goRootLibJsBridge)
+	if _, err := os.Stat(bridge); err == nil {
+		return bridge
+	}
+
+	return filepath.Join(goRoot, goRootJsBridge)
+}
+
+// srcHash calculates an uber hash from all source modules and the toolchain.
+func (p *Project) srcHash() [32]byte {
+	hasher := sha256.New()
+	hasher.Write(p.toolchainFingerprint[:])
+	for _, mod := range p.mods {
+		hasher.Write(mod.src.Hash[:])
+	}
+
+	hasher.Write(p.main.src.Hash[:])
+
+	var r [32]byte
+	tmp := hasher.Sum(nil)
+	copy(r[:], tmp)
+
+	return r
+}
+
+// Build syncs the file tree of all modules into the build destination directory and compiles the web assembly.
+// Returns the unique hash of the last build.
+func (p *Project) Build(opts Options) ([32]byte, error) {
+	if opts.BuildLogFile != "" && p.buildLog == nil {
+		l, err := openBuildLog(opts.BuildLogFile, opts.BuildLogMaxBytes)
+		if err != nil {
+			return p.lastBuildHash, err
+		}
+
+		p.buildLog = l
+	}
+
+	p.logHash = "-"
+	start := time.Now()
+	hash, err := p.build(opts)
+
+	p.statsLock.Lock()
+	if p.skipped {
+		p.stats.skipped++
+	} else {
+		p.stats.add(time.Since(start), err)
+	}
+	p.statsLock.Unlock()
+
+	if err != nil {
+		p.buildLog.Println(p.logHash, "result", err.Error())
+	} else {
+		p.buildLog.Println(p.logHash, "result", "ok")
+	}
+
+	if err := p.buildLog.Flush(); err != nil {
+		log.Println("unable to write build log", err)
+	}
+
+	return hash, err
+}
+
+// Close releases the build log file.
+func (p *Project) Close() error {
+	return p.buildLog.Close()
+}
+
+// Stats returns the summary of all Builds. It may be invoked concurrently to a Build.
+func (p *Project) Stats() BuildStats {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	return p.stats.summary()
+}
+
+// LastVersion returns the BuildInfo.Version of the last successful Build, which is the hash of its app.wasm.
+func (p *Project) LastVersion() string {
+	return p.lastVersion
+}
+
+// LastTimings returns the phase durations of the last Build.
+func (p *Project) LastTimings() BuildTimings {
+	return p.timings
+}
+
+func (p *Project) build(opts Options) ([32]byte, error) {
+	start := time.Now()
+	p.timings = BuildTimings{}
+	p.skipped = false
+	defer func() {
+		p.timings.Total = time.Since(start)
+		log.Println(fmt.Sprintf("build duration: %v", p.timings.Total))
+	}()
+
+	// trees of different hashers cannot be compared, so everything must be hashed again
+	if opts.FastHash != p.fastHash {
+		opts.Force = true
+		p.fastHash = opts.FastHash
+	}
+
+	manifest, err := p.loadManifest()
+	if err != nil {
+		return p.lastBuildHash, err
+	}
+
+	opts = manifest.Apply(opts, p.srcPath)
+
+	p.hashProgress = opts.HashProgress
+
+	if opts.Force || p.templateHashCache == nil {
+		p.templateHashCache = map[string][32]byte{}
+	}
+
+	if err := os.MkdirAll(p.dstPath, os.ModePerm); err != nil {
+		return p.lastBuildHash, fmt.Errorf("unable to create build directory: %s: %w", p.dstPath, err)
+	}
+
+	p.workPath = p.dstPath
+
+	fingerprint, err := fingerprintToolchain(opts.WasmExecJS)
+	if err != nil {
+		return p.lastBuildHash, err
+	}
+
+	toolchainChanged := fingerprint != p.toolchainFingerprint
+	p.toolchainFingerprint = fingerprint
+	if toolchainChanged && Debug {
+		log.Println("toolchain has been changed")
+	}
+
+	// the bridge may have been removed by CleanGenerated, a custom bridge may have been changed or go may have
+	// been upgraded
+	if _, err := os.Stat(filepath.Join(p.dstPath, wasmBridgeFilename)); os.IsNotExist(err) || opts.WasmExecJS != "" || p.wasmExecJS != "" || toolchainChanged {
+		if err := p.copyWasmBridge(opts.WasmExecJS); err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to provide the current Go WASM bridge: %w", err)
+		}
+	}
+
+	// must be checked before loadMods tidies the module
+	if opts.GoModTidyCheck && !p.tidyChecked {
+		if err := gotool.ModTidyCheck(p.srcPath, opts.GoEnv()...); err != nil {
+			return p.lastBuildHash, err
+		}
+
+		p.tidyChecked = true
+	}
+
+	if err := p.loadMods(opts); err != nil {
+		return p.lastBuildHash, fmt.Errorf("unable to load modules: %w", err)
+	}
+
+	// format before hashing, so that the formatted files do not trigger another build
+	var formatted []string
+	var formatErr error
+	if opts.GoFmt {
+		formatted, formatErr = gotool.Fmt(p.srcPath, true)
+	}
+
+	var before *hashtree.Node
+	if p.main.src != nil {
+		before = p.main.src.Clone()
+	}
+
+	hashStart := time.Now()
+	if err := p.refresh(opts.Force); err != nil {
+		return p.lastBuildHash, fmt.Errorf("unable to refresh file hashes: %w", err)
+	}
+
+	p.timings.Hash = time.Since(hashStart)
+
+	// only compare originally synced hashes, to avoid any other copy work, which just creates invalid
+	// intermediate builder states
+	uberHash := p.srcHash()
+	p.logHash = hex.EncodeToString(uberHash[:])
+	if opts.GoFmt {
+		p.logFormat(formatted, formatErr)
+	}
+
+	if uberHash == p.lastBuildHash && !opts.ForceRebuild {
+		if Debug {
+			log.Println(fmt.Sprintf("hash unchanged, no build required: %s", hex.EncodeToString(uberHash[:])))
+		}
+
+		p.skipped = true
+
+		return p.lastBuildHash, nil
+	}
+
+	if opts.GoGenerate {
+		if err := p.generate(opts.Force, opts.GoEnv()); err != nil {
+			return p.lastBuildHash, err
+		}
+	}
+
+	// reset our last build hash, otherwise we may get weired build/bug/revert/non-build inconsistencies
+	for i := range p.lastBuildHash {
+		p.lastBuildHash[i] = 0
+	}
+
+	if Debug {
+		log.Println(fmt.Sprintf("build hash changed, old: %s new: %s", hex.EncodeToString(p.lastBuildHash[:]), hex.EncodeToString(uberHash[:])))
+	}
+
+	if opts.AtomicOutput {
+		if err := p.prepareStaging(); err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to prepare staging directory: %w", err)
+		}
+	}
+
+	if err := p.checkStaticConflicts(opts.FailOnStaticConflict); err != nil {
+		return p.lastBuildHash, err
+	}
+
+	// copy all original stuff over, sync also deletes generated extra files like wasm and templates
+	if err := p.sync(); err != nil {
+		return p.lastBuildHash, fmt.Errorf("cannot sync file trees: %w", err)
+	}
+
+	// try to actually build, every other error until now was critical
+	buildInfo := BuildInfo{
+		Time:          time.Now(),
+		Version:       hex.EncodeToString(uberHash[:]),
+		SourceVersion: hex.EncodeToString(uberHash[:]),
+		HotReload:     opts.HotReload,
+		Extra:         opts.Extra,
+		BasePath:      strings.TrimSuffix(opts.BasePath, "/"),
+	}
+
+	buildInfo.WasmProfile = opts.WasmProfile
+
+	buildInfo.ReloadDelayMs = int(opts.ReloadDelay / time.Millisecond)
+	buildInfo.TotalStaticBytes = p.staticBytes()
+
+	if opts.Reproducible {
+		buildInfo.Time = time.Time{}
+	}
+
+	if before != nil {
+		buildInfo.Changes = hashtree.Diff(before.Flatten(""), p.main.src.Flatten(""))
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Println("unable to read hostname", err)
+	}
+
+	buildInfo.Host = hostname
+
+	gitCommit, err := git.Head(p.srcPath)
+	if err != nil {
+		log.Println("unable to read git head", err)
+	}
+
+	buildInfo.Commit = gitCommit
+
+	if gitCommit != "" {
+		shortCommit, err := git.ShortHash(p.srcPath)
+		if err != nil {
+			log.Println("unable to read git short hash", err)
+		}
+
+		buildInfo.ShortCommit = shortCommit
+
+		describe, err := git.Describe(p.srcPath)
+		if err != nil {
+			log.Println("unable to describe git head", err)
+		}
+
+		buildInfo.GitDescribe = describe
+	}
+
+	goVersion, err := gotool.Version()
+	if err != nil {
+		log.Println("unable to get go compiler version", err)
+	}
+
+	buildInfo.Compiler = goVersion
+
+	wasmFile := filepath.Join(p.workPath, wasmFilename)
+	compileStart := time.Now()
+	if opts.WasmProfile != "" {
+		err = gotool.BuildWasmWithProfile(p.mods[0].mod, wasmFile, opts.GOOS, opts.GOARCH, opts.WasmProfile, opts.GOFlags)
+	} else {
+		err = gotool.BuildWasm(p.mods[0].mod, wasmFile, opts.GOOS, opts.GOARCH, opts.GOFlags)
+	}
+
+	p.timings.Compile = time.Since(compileStart)
+	if err != nil {
+		buildInfo.CompileError = err
+		buildInfo.Diagnostics = parseCompilerOutput(err.Error())
+		p.buildLog.Println(p.logHash, "build", err.Error())
+		if Debug {
+			log.Println("wasm build failed", err)
+		}
+	} else {
+		buildInfo.Wasm = true
+		p.buildLog.Println(p.logHash, "build", "wasm build successful")
+		if Debug {
+			log.Println("wasm build successful")
+		}
+
+		stat, err := os.Stat(wasmFile)
+		if err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to stat wasm file: %w", err)
+		}
+
+		buildInfo.WasmSizeBytes = stat.Size()
+
+		// equal binaries of different sources must not bust the caches
+		wasmHash, err := hashtree.Read(wasmFile, p.newHasher())
+		if err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to hash wasm file: %w", err)
+		}
+
+		buildInfo.Version = hex.EncodeToString(wasmHash[:])
+		metrics.SetWasmSize(buildInfo.WasmSizeBytes)
+
+		if opts.MaxWasmSizeBytes > 0 && buildInfo.WasmSizeBytes > opts.MaxWasmSizeBytes {
+			buildInfo.CompileError = WasmTooLargeError{
+				Size:   buildInfo.WasmSizeBytes,
+				Budget: opts.MaxWasmSizeBytes,
+			}
 		}
 	}
 
+	if err := p.removeOrphanedTemplateOutputs(); err != nil {
+		return p.lastBuildHash, err
+	}
+
 	// apply all templates to files like *.gocss or *.gohtml
-	allFiles, err := listAllFiles(p.dstPath)
+	templateStart := time.Now()
+	allFiles, err := listAllFiles(p.workPath)
 	if err != nil {
 		return p.lastBuildHash, err
 	}
 
+	if opts.VerifyCSSClasses {
+		buildInfo.CSSWarnings = css.VerifyTemplateClasses(p.workPath, cssClasses(allFiles))
+		for _, warning := range buildInfo.CSSWarnings {
+			log.Println(fmt.Sprintf("warning: undefined css class: %s", warning))
+		}
+	}
+
+	// the output of a template depends on its source and the BuildInfo
+	infoHash, infoHashed := buildInfo.renderHash()
+
 GoTemplateLoop:
 	for _, file := range allFiles {
 		ext := strings.ToLower(filepath.Ext(file))
@@ -407,13 +1345,46 @@ GoTemplateLoop:
 					log.Println(fmt.Sprintf("found template file: %s", file))
 				}
 
-				_, err := buildInfo.applyTemplate(file)
+				hash, hashErr := hashtree.Read(file, p.newHasher())
+				cacheable := hashErr == nil && infoHashed
+				hash = sha256.Sum256(append(hash[:], infoHash[:]...))
+				if cacheable && p.templateUnchanged(file, hash) {
+					if Debug {
+						log.Println(fmt.Sprintf("skipping unchanged template file: %s", file))
+					}
+
+					if opts.KeepTemplateSources {
+						if err := p.keepTemplateOutput(file); err != nil {
+							return p.lastBuildHash, err
+						}
+					} else if err := removeTemplateSource(file); err != nil {
+						return p.lastBuildHash, err
+					}
+
+					continue GoTemplateLoop
+				}
+
+				_, err := buildInfo.applyTemplate(file, useSafeTemplate(file, opts), opts.KeepTemplateSources)
+				if err == nil && cacheable {
+					p.templateHashCache[p.templateKey(file)] = hash
+				} else {
+					delete(p.templateHashCache, p.templateKey(file))
+				}
+
+				if err == nil && opts.KeepTemplateSources {
+					if err := p.keepTemplateOutput(file); err != nil {
+						return p.lastBuildHash, err
+					}
+				}
+
 				if err != nil {
 					log.Println("template error", err)
+					p.buildLog.Println(p.logHash, "template", err.Error())
 				}
 
 				if err != nil && buildInfo.CompileError == nil {
 					buildInfo.CompileError = err
+					buildInfo.Diagnostics = parseCompilerOutput(err.Error())
 					break GoTemplateLoop
 				}
 
@@ -421,6 +1392,20 @@ GoTemplateLoop:
 		}
 	}
 
+	p.timings.Template = time.Since(templateStart)
+
+	if opts.GzipStatic {
+		if err := p.gzipStatic(); err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to pre-compress files: %w", err)
+		}
+	}
+
+	if opts.AtomicOutput {
+		if err := p.publishStaging(opts); err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to publish staging directory: %w", err)
+		}
+	}
+
 	if buildInfo.HasError() {
 		if Debug {
 			log.Println("build has errors")
@@ -428,15 +1413,270 @@ GoTemplateLoop:
 		return p.lastBuildHash, CompileErr{delegate: buildInfo.CompileError}
 	}
 
+	if err := checkWasmFile(filepath.Join(p.dstPath, wasmFilename)); err != nil {
+		return p.lastBuildHash, err
+	}
+
 	p.lastBuildHash = uberHash
+	p.lastVersion = buildInfo.Version
 
 	if Debug {
 		log.Println(fmt.Sprintf("build completed: %s", hex.EncodeToString(p.lastBuildHash[:])))
 	}
 
+	if opts.KeepBuilds > 0 {
+		if err := p.snapshot(uberHash, opts.KeepBuilds); err != nil {
+			return p.lastBuildHash, fmt.Errorf("unable to snapshot build: %w", err)
+		}
+	}
+
 	return p.lastBuildHash, nil
 }
 
+// cssClasses returns the class names defined by all .css files, mapped by their Go identifier. Unreadable files
+// are skipped.
+func cssClasses(files []string) map[string]string {
+	classes := map[string]string{}
+	for _, file := range files {
+		if strings.ToLower(filepath.Ext(file)) != ".css" {
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Println("unable to read css file", err)
+			continue
+		}
+
+		for identifier, className := range css.ParseClassNames(buf) {
+			classes[identifier] = className
+		}
+	}
+
+	return classes
+}
+
+// checkWasmFile returns an InternalBuildError, if the wasm file of a successful build is missing or empty.
+func checkWasmFile(fname string) error {
+	stat, err := os.Stat(fname)
+	if err != nil {
+		return InternalBuildError{File: fname, Reason: "wasm file is missing", Err: err}
+	}
+
+	if stat.Size() == 0 {
+		return InternalBuildError{File: fname, Reason: "wasm file is empty"}
+	}
+
+	return nil
+}
+
+// templateKey returns the key of the template file for the templateHashCache.
+func (p *Project) templateKey(fname string) string {
+	if rel, err := filepath.Rel(p.workPath, fname); err == nil {
+		return rel
+	}
+
+	return fname
+}
+
+// templateUnchanged returns true, if the template has already been applied with the same hash and its
+// target file still exists.
+func (p *Project) templateUnchanged(fname string, hash [32]byte) bool {
+	cached, ok := p.templateHashCache[p.templateKey(fname)]
+	if !ok || cached != hash {
+		return false
+	}
+
+	target := templateTargetFile(fname)
+	if target == fname {
+		// the template has been overwritten in place by its output, so there is no source to compare
+		return false
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// keepTemplateOutput registers the rendered file of the given template as extra file, so that sync keeps it next
+// to its source. The source itself is part of the source tree and is kept anyway.
+func (p *Project) keepTemplateOutput(fname string) error {
+	target := templateTargetFile(fname)
+	if target == fname {
+		return nil
+	}
+
+	rel, err := filepath.Rel(p.workPath, target)
+	if err != nil {
+		return fmt.Errorf("unable to relativize template output: %w", err)
+	}
+
+	srcRel, err := filepath.Rel(p.workPath, fname)
+	if err != nil {
+		return fmt.Errorf("unable to relativize template: %w", err)
+	}
+
+	if !p.isExtraDstFile(rel) {
+		p.extraDstFiles = append(p.extraDstFiles, rel)
+	}
+
+	if p.templateOutputs == nil {
+		p.templateOutputs = map[string]string{}
+	}
+
+	p.templateOutputs[rel] = srcRel
+
+	return nil
+}
+
+// removeOrphanedTemplateOutputs deletes each rendered file kept by keepTemplateOutput, whose template source has
+// been removed by sync. Otherwise, it would be kept forever as an extra file.
+func (p *Project) removeOrphanedTemplateOutputs() error {
+	for rel, srcRel := range p.templateOutputs {
+		if _, err := os.Stat(filepath.Join(p.workPath, srcRel)); err == nil {
+			continue
+		}
+
+		if Debug {
+			log.Println(fmt.Sprintf("removing orphaned template output %s", rel))
+		}
+
+		if err := os.RemoveAll(filepath.Join(p.workPath, rel)); err != nil {
+			return fmt.Errorf("unable to remove orphaned template output: %w", err)
+		}
+
+		for i, name := range p.extraDstFiles {
+			if name == rel {
+				p.extraDstFiles = append(p.extraDstFiles[:i], p.extraDstFiles[i+1:]...)
+				break
+			}
+		}
+
+		delete(p.templateOutputs, rel)
+	}
+
+	return nil
+}
+
+// removeTemplateSource removes a template file, whose application has been skipped, just like applyTemplate
+// would have done.
+func removeTemplateSource(fname string) error {
+	if templateTargetFile(fname) == fname {
+		return nil
+	}
+
+	if err := os.RemoveAll(fname); err != nil {
+		return fmt.Errorf("cannot remove source file: %w", err)
+	}
+
+	return nil
+}
+
+// OutputHashes returns the content hashes of all files of the output directory. The keys are slash separated
+// and absolute to the output directory, e.g. /index.html. Dotted files are not included.
+func (p *Project) OutputHashes() (map[string][32]byte, error) {
+	if p.dst == nil {
+		p.dst = hashtree.NewNode()
+		p.dst.Mode = os.ModeDir
+	}
+
+	// generated files have been written after the last refresh, so read the output again
+	if err := hashtree.ReadDir(p.dstPath, p.dst, false, p.newHasher()); err != nil {
+		return nil, fmt.Errorf("unable to hash output: %w", err)
+	}
+
+	res := map[string][32]byte{}
+	for _, file := range p.dst.Flatten("") {
+		if file.Node.Mode.IsRegular() {
+			res["/"+filepath.ToSlash(file.Filename)] = file.Node.Hash
+		}
+	}
+
+	return res, nil
+}
+
+// prepareStaging copies the current output into a fresh staging directory, which becomes the workPath.
+func (p *Project) prepareStaging() error {
+	staging := p.dstPath + stagingSuffix
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("unable to remove stale staging directory: %w", err)
+	}
+
+	if err := os.MkdirAll(staging, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create staging directory: %w", err)
+	}
+
+	if err := io.CopyDir(staging, p.dstPath); err != nil {
+		return fmt.Errorf("unable to copy output into staging directory: %w", err)
+	}
+
+	p.workPath = staging
+
+	// the copies have other ModTimes, so the dst tree must be refreshed
+	if err := hashtree.ReadDir(p.workPath, p.dst, false, p.newHasher()); err != nil {
+		return fmt.Errorf("unable to hash staging: %w", err)
+	}
+
+	return nil
+}
+
+// publishStaging replaces the output directory with the staging directory. The directory is renamed, which
+// leaves only a tiny window without any output. If that fails, e.g. on windows where open files cannot be renamed,
+// the content is moved manually while serving is paused.
+func (p *Project) publishStaging(opts Options) error {
+	staging := p.workPath
+	old := p.dstPath + oldSuffix
+	p.workPath = p.dstPath
+
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("unable to remove stale old output: %w", err)
+	}
+
+	if err := os.Rename(p.dstPath, old); err == nil {
+		if err := os.Rename(staging, p.dstPath); err != nil {
+			_ = os.Rename(old, p.dstPath)
+			return fmt.Errorf("unable to rename staging directory: %w", err)
+		}
+
+		return os.RemoveAll(old)
+	}
+
+	if Debug {
+		log.Println("unable to rename output directory, moving staging content manually")
+	}
+
+	if opts.PauseServing != nil {
+		resume := opts.PauseServing()
+		defer resume()
+	}
+
+	dstFiles, err := ioutil.ReadDir(p.dstPath)
+	if err != nil {
+		return fmt.Errorf("unable to list output directory: %w", err)
+	}
+
+	for _, file := range dstFiles {
+		if err := os.RemoveAll(filepath.Join(p.dstPath, file.Name())); err != nil {
+			return fmt.Errorf("unable to clear output directory: %w", err)
+		}
+	}
+
+	stagingFiles, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return fmt.Errorf("unable to list staging directory: %w", err)
+	}
+
+	for _, file := range stagingFiles {
+		if err := os.Rename(filepath.Join(staging, file.Name()), filepath.Join(p.dstPath, file.Name())); err != nil {
+			return fmt.Errorf("unable to move staging file: %w", err)
+		}
+	}
+
+	return os.RemoveAll(staging)
+}
+
 func listAllFiles(root string) ([]string, error) {
 	var res []string
 
@@ -462,3 +1702,10 @@ func listAllFiles(root string) ([]string, error) {
 
 	return res, nil
 }
+
+// isVendored returns true, if the module in dir contains a vendor directory.
+func isVendored(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "vendor"))
+
+	return err == nil && info.IsDir()
+}
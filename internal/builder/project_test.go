@@ -0,0 +1,281 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/golangee/gotrino-make/internal/gotool"
+	"github.com/golangee/gotrino-make/internal/hashtree"
+	"github.com/golangee/gotrino-make/internal/io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func staticPart(path string, files ...string) *Part {
+	root := hashtree.NewNode()
+	root.Mode = os.ModeDir
+	for _, name := range files {
+		root.Add(&hashtree.Node{Name: name})
+	}
+
+	return &Part{mod: gotool.Module{Path: path, Version: "v1.0.0"}, src: root}
+}
+
+func TestStaticConflicts(t *testing.T) {
+	p := &Project{mods: []*Part{
+		staticPart("example.com/app", "main.css"),
+		staticPart("example.com/a", "main.css", "a.css", "theme.css"),
+		staticPart("example.com/b", "main.css", "theme.css"),
+	}}
+
+	conflicts := p.staticConflicts()
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts but got %+v", conflicts)
+	}
+
+	for i, name := range []string{"main.css", "theme.css"} {
+		c := conflicts[i]
+		if c.Filename != name || c.Used.Path != "example.com/a" || len(c.Shadowed) != 1 || c.Shadowed[0].Path != "example.com/b" {
+			t.Fatalf("unexpected conflict: %+v", c)
+		}
+	}
+
+	if err := p.checkStaticConflicts(true); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestStaticBytes(t *testing.T) {
+	app := staticPart("example.com/app", "main.css")
+	app.src.Find("main.css").Size = 100
+	dep := staticPart("example.com/a", "main.css", "a.css")
+	dep.src.Find("main.css").Size = 1000
+	dep.src.Find("a.css").Size = 10
+
+	p := &Project{mods: []*Part{app, dep}}
+	if got := p.staticBytes(); got != 110 {
+		t.Fatalf("expected the shadowed file not to be counted but got %d", got)
+	}
+}
+
+func TestCheckWasmFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, wasmFilename)
+	var internalErr InternalBuildError
+	if err := checkWasmFile(fname); !errors.As(err, &internalErr) {
+		t.Fatalf("expected an InternalBuildError for a missing file but got %v", err)
+	}
+
+	if err := ioutil.WriteFile(fname, nil, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkWasmFile(fname); !errors.As(err, &internalErr) {
+		t.Fatalf("expected an InternalBuildError for an empty file but got %v", err)
+	}
+
+	if err := ioutil.WriteFile(fname, []byte("\x00asm"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkWasmFile(fname); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashModFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/a\n"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	withoutSum, err := hashModFiles(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.sum"), []byte("example.com/b v1.0.0 h1:abc=\n"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	withSum, err := hashModFiles(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withoutSum == withSum {
+		t.Fatal("expected a changed go.sum to change the hash")
+	}
+
+	again, err := hashModFiles(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != withSum {
+		t.Fatal("expected a stable hash")
+	}
+}
+
+func TestBuildFixture(t *testing.T) {
+	prj, err := NewProject(t.TempDir(), "../../testdata/hello-wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer prj.Close()
+
+	// the fixture has no dependencies, so that tidy is not required
+	opts := Options{SkipModTidy: true, TemplatePatterns: []string{".gohtml"}}
+	if _, err := prj.Build(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app.wasm", "wasm_exec.js", "index.html"} {
+		if _, err := os.Stat(filepath.Join(prj.dstPath, name)); err != nil {
+			t.Fatalf("expected %s in the output: %v", name, err)
+		}
+	}
+
+	wasmHash, err := hashtree.Read(filepath.Join(prj.dstPath, "app.wasm"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(prj.dstPath, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version := "app.wasm?v=" + hex.EncodeToString(wasmHash[:]); !strings.Contains(string(index), version) {
+		t.Fatalf("expected the wasm hash as version but got %s", string(index))
+	}
+
+	if got := prj.LastVersion(); got != hex.EncodeToString(wasmHash[:]) {
+		t.Fatalf("expected the wasm hash as last version but got %s", got)
+	}
+}
+
+func TestIsVendored(t *testing.T) {
+	dir := t.TempDir()
+	if isVendored(dir) {
+		t.Fatalf("expected no vendor directory in %s", dir)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "vendor"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if !isVendored(dir) {
+		t.Fatalf("expected a vendor directory in %s", dir)
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	var stats buildStats
+	if got := stats.summary(); got != (BuildStats{}) {
+		t.Fatalf("expected empty stats but got %+v", got)
+	}
+
+	stats.skipped++
+	if got := stats.summary(); got != (BuildStats{Skipped: 1}) {
+		t.Fatalf("expected only a skipped build but got %+v", got)
+	}
+
+	stats.add(20*time.Millisecond, nil)
+	stats.add(10*time.Millisecond, errors.New("compile error"))
+	stats.add(60*time.Millisecond, nil)
+
+	want := BuildStats{TotalBuilds: 3, Succeeded: 2, Failed: 1, Skipped: 1, AvgDurationMs: 30, MinDurationMs: 10, MaxDurationMs: 60}
+	if got := stats.summary(); got != want {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+}
+
+func TestTemplateCacheBuildInfo(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := io.CopyDir(srcDir, "../../testdata/hello-wasm"); err != nil {
+		t.Fatal(err)
+	}
+
+	prj, err := NewProject(t.TempDir(), srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer prj.Close()
+
+	opts := Options{SkipModTidy: true, KeepTemplateSources: true, Reproducible: true, TemplatePatterns: []string{".gohtml"}}
+	index := func() string {
+		if _, err := prj.Build(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := ioutil.ReadFile(filepath.Join(prj.dstPath, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(buf)
+	}
+
+	before := index()
+
+	// the template is unchanged, but the new app.wasm changes the BuildInfo.Version
+	mainGo := filepath.Join(srcDir, "cmd", "wasm", "main.go")
+	src := "package main\n\nimport \"syscall/js\"\n\nfunc main() {\n\tjs.Global().Get(\"document\").Get(\"body\").Set(\"innerHTML\", \"changed\")\n}\n"
+	if err := ioutil.WriteFile(mainGo, []byte(src), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := index(); after == before {
+		t.Fatalf("expected index.html to change with the app.wasm but got %s", after)
+	}
+}
+
+func TestGoEnv(t *testing.T) {
+	opts := Options{ExtraEnv: []string{"GOFLAGS=-mod=mod", "GONOSUMDB=*.example.com"}}
+	if got := opts.GoEnv(); !reflect.DeepEqual(got, opts.ExtraEnv) {
+		t.Fatalf("expected the extra env but got %v", got)
+	}
+
+	opts.GOFlags = "-modcacherw"
+	want := []string{"GOFLAGS=-mod=mod", "GONOSUMDB=*.example.com", "GOFLAGS=-modcacherw"}
+	if got := opts.GoEnv(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v but got %v", want, got)
+	}
+
+	if len(opts.ExtraEnv) != 2 {
+		t.Fatalf("expected the extra env to be unchanged but got %v", opts.ExtraEnv)
+	}
+}
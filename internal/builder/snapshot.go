@@ -0,0 +1,154 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/golangee/gotrino-make/internal/io"
+	"github.com/golangee/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotFolder     = "builds"
+	snapshotTimeLayout = "20060102T150405.000000000Z"
+)
+
+// A Snapshot is a copy of the output of a successful build, which can be restored by Rollback.
+type Snapshot struct {
+	Hash string    // Hash is the hex encoded build hash.
+	Time time.Time // Time is the completion of the build.
+	Dir  string    // Dir is the directory which contains the copy.
+}
+
+// SnapshotDir returns the directory of all snapshots, which is a sibling of the given output directory.
+func SnapshotDir(dstPath string) string {
+	return filepath.Join(filepath.Dir(dstPath), snapshotFolder)
+}
+
+// snapshot copies the output into a new snapshot and deletes the oldest snapshots, so that only keep
+// snapshots remain.
+func (p *Project) snapshot(hash [32]byte, keep int) error {
+	name := time.Now().UTC().Format(snapshotTimeLayout) + "-" + hex.EncodeToString(hash[:])
+	dir := filepath.Join(SnapshotDir(p.dstPath), name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create snapshot dir: %w", err)
+	}
+
+	if err := io.CopyDir(dir, p.dstPath); err != nil {
+		_ = os.RemoveAll(dir)
+		return fmt.Errorf("unable to copy output into snapshot: %w", err)
+	}
+
+	snapshots, err := ListSnapshots(p.dstPath)
+	if err != nil {
+		return err
+	}
+
+	for len(snapshots) > keep {
+		if Debug {
+			log.Println(fmt.Sprintf("removing old snapshot %s", snapshots[0].Dir))
+		}
+
+		if err := os.RemoveAll(snapshots[0].Dir); err != nil {
+			return fmt.Errorf("unable to remove old snapshot: %w", err)
+		}
+
+		snapshots = snapshots[1:]
+	}
+
+	return nil
+}
+
+// ListSnapshots returns all snapshots of the given output directory, the oldest first.
+func ListSnapshots(dstPath string) ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(SnapshotDir(dstPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to list snapshots: %w", err)
+	}
+
+	var res []Snapshot
+	for _, file := range files {
+		idx := strings.LastIndex(file.Name(), "-")
+		if !file.IsDir() || idx < 0 {
+			continue
+		}
+
+		t, err := time.Parse(snapshotTimeLayout, file.Name()[:idx])
+		if err != nil {
+			continue
+		}
+
+		res = append(res, Snapshot{
+			Hash: file.Name()[idx+1:],
+			Time: t,
+			Dir:  filepath.Join(SnapshotDir(dstPath), file.Name()),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+
+	return res, nil
+}
+
+// Rollback replaces the content of the output directory with the latest snapshot, whose hash starts with
+// the given prefix.
+func Rollback(dstPath, hashPrefix string) error {
+	if hashPrefix == "" {
+		return fmt.Errorf("no snapshot hash given")
+	}
+
+	snapshots, err := ListSnapshots(dstPath)
+	if err != nil {
+		return err
+	}
+
+	var match *Snapshot
+	for i := range snapshots {
+		if strings.HasPrefix(snapshots[i].Hash, hashPrefix) {
+			match = &snapshots[i]
+		}
+	}
+
+	if match == nil {
+		return fmt.Errorf("no snapshot found for hash: %s", hashPrefix)
+	}
+
+	if err := os.RemoveAll(dstPath); err != nil {
+		return fmt.Errorf("unable to remove output: %w", err)
+	}
+
+	if err := os.MkdirAll(dstPath, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create output: %w", err)
+	}
+
+	if err := io.CopyDir(dstPath, match.Dir); err != nil {
+		return fmt.Errorf("unable to restore snapshot: %w", err)
+	}
+
+	return nil
+}
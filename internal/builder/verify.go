@@ -0,0 +1,67 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"github.com/golangee/gotrino-make/internal/hashtree"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Verify builds the source module again with Options.Force into a temporary directory and compares the result
+// with the existing output in dstPath. It returns the relative names of all files, which are not bit-for-bit
+// identical or which only exist in one of both outputs. Options.Reproducible should be set for both builds,
+// otherwise the embedded build time will always differ.
+func Verify(dstPath, srcPath string, opts Options) ([]string, error) {
+	tmpDir, err := ioutil.TempDir("", "gotrino-verify")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create verify directory: %w", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	// the second build must not touch any persistent state of the first one
+	opts.Force = true
+	opts.HotReload = false
+	opts.KeepBuilds = 0
+	opts.BuildLogFile = ""
+	opts.PauseServing = nil
+
+	verifyPath := filepath.Join(tmpDir, "www")
+	prj, err := NewProject(verifyPath, srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer prj.Close()
+
+	if _, err := prj.Build(opts); err != nil {
+		return nil, fmt.Errorf("unable to create verification build: %w", err)
+	}
+
+	expected := hashtree.NewNode()
+	if err := hashtree.ReadDir(dstPath, expected, false, nil); err != nil {
+		return nil, fmt.Errorf("unable to hash output: %w", err)
+	}
+
+	actual := hashtree.NewNode()
+	if err := hashtree.ReadDir(verifyPath, actual, false, nil); err != nil {
+		return nil, fmt.Errorf("unable to hash verification output: %w", err)
+	}
+
+	return hashtree.Diff(expected.Flatten(""), actual.Flatten("")), nil
+}
@@ -15,7 +15,10 @@
 package css
 
 import (
+	"bytes"
 	"fmt"
+	"go/format"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"sort"
@@ -24,12 +27,25 @@ import (
 	"unicode"
 )
 
+// DefaultTailwindVersion is the tailwind version, which is downloaded by DownloadTailwind.
+const DefaultTailwindVersion = "2.0.1"
+
 func DownloadTailwind() ([]byte, error) {
-	res, err := http.Get("https://unpkg.com/tailwindcss@2.0.1/dist/tailwind.css")
+	return downloadTailwind(DefaultTailwindVersion)
+}
+
+func downloadTailwind(version string) ([]byte, error) {
+	res, err := http.Get("https://unpkg.com/tailwindcss@" + version + "/dist/tailwind.css")
 	if err != nil {
 		return nil, err
 	}
 
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download tailwind %s: %s", version, res.Status)
+	}
+
 	buf, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
@@ -38,6 +54,41 @@ func DownloadTailwind() ([]byte, error) {
 	return buf, nil
 }
 
+// GenerateTailwindConstants downloads the given tailwind version and writes all class names as Go constants
+// into outFile, which declares the given package. The variable is named Tailwind. This is intended to be
+// used by go:generate. If any scanDirs are given, only the classes used by their Go files are written.
+func GenerateTailwindConstants(version, outFile, pkg string, scanDirs ...string) error {
+	if version == "" {
+		version = DefaultTailwindVersion
+	}
+
+	tailwind, err := downloadTailwind(version)
+	if err != nil {
+		return err
+	}
+
+	classes := ParseClassNames(tailwind)
+	if len(scanDirs) > 0 {
+		used, err := ScanGoFiles(scanDirs)
+		if err != nil {
+			return err
+		}
+
+		classes = Purge(classes, used)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteClassNames(classes, buf, pkg, "Tailwind"); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(outFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("unable to write go file: %w", err)
+	}
+
+	return nil
+}
+
 func text2GoIdentifier(p string) string {
 	sb := &strings.Builder{}
 	upCase := true
@@ -68,8 +119,15 @@ func unescape(str string) string {
 	return strings.ReplaceAll(str, "\\", "")[1:]
 }
 
-func PrintClassNamesAsGoConstants(buf []byte) error {
+// PrintClassNamesAsGoConstants parses all css class names from buf and writes a complete Go source file into w.
+// The file declares the given package and a struct variable with the given name, whose fields contain the
+// class names.
+func PrintClassNamesAsGoConstants(buf []byte, w io.Writer, pkg, varName string) error {
+	return WriteClassNames(ParseClassNames(buf), w, pkg, varName)
+}
 
+// ParseClassNames returns all css class names from buf, mapped by their Go identifier.
+func ParseClassNames(buf []byte) map[string]string {
 	uniqueClasses := map[string]string{}
 NEXT_LINE:
 	for _, line := range strings.Split(string(buf), "\n") {
@@ -103,6 +161,12 @@ NEXT_LINE:
 
 	}
 
+	return uniqueClasses
+}
+
+// WriteClassNames writes a complete Go source file into w, which declares the given package and a struct variable
+// with the given name, whose fields contain the class names mapped by their Go identifier.
+func WriteClassNames(uniqueClasses map[string]string, w io.Writer, pkg, varName string) error {
 	var varNames []string
 	for n := range uniqueClasses {
 		varNames = append(varNames, n)
@@ -110,10 +174,30 @@ NEXT_LINE:
 
 	sort.Strings(varNames)
 
+	src := &strings.Builder{}
+	src.WriteString("// Code generated by gotrino-make. DO NOT EDIT.\n\n")
+	src.WriteString("package " + pkg + "\n\n")
+	src.WriteString(fmt.Sprintf("// %s contains %d css class names.\n", varName, len(varNames)))
+	src.WriteString("var " + varName + " = struct {\n")
 	for _, n := range varNames {
-		fmt.Println(n + " = " + strconv.Quote(uniqueClasses[n]))
+		src.WriteString(n + " string\n")
+	}
+
+	src.WriteString("}{\n")
+	for _, n := range varNames {
+		src.WriteString(n + ": " + strconv.Quote(uniqueClasses[n]) + ",\n")
+	}
+
+	src.WriteString("}\n")
+
+	formatted, err := format.Source([]byte(src.String()))
+	if err != nil {
+		return fmt.Errorf("unable to format generated source: %w", err)
+	}
+
+	if _, err := w.Write(formatted); err != nil {
+		return fmt.Errorf("unable to write generated source: %w", err)
 	}
 
-	fmt.Println("got", len(varNames))
 	return nil
 }
@@ -2,6 +2,7 @@ package css
 
 import (
 	"fmt"
+	"os"
 	"testing"
 )
 
@@ -11,7 +12,7 @@ func TestPrintClassNamesAsGoConstants(t *testing.T) {
 		t.Fatal()
 	}
 
-	if err := PrintClassNamesAsGoConstants(tailwind); err != nil {
+	if err := PrintClassNamesAsGoConstants(tailwind, os.Stdout, "tailwind", "Tailwind"); err != nil {
 		t.Fatal(err)
 	}
 }
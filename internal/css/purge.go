@@ -0,0 +1,120 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package css
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// regexClassName matches values which look like css class names, e.g. md:px-4 or hover:bg-blue-500.
+var regexClassName = regexp.MustCompile(`^[a-zA-Z0-9]+([-:][a-zA-Z0-9]+)*$`)
+
+// ScanGoFiles parses all Go files below the given directories and returns the set of used class names. A string
+// literal may contain multiple space separated class names. Because the classes are usually referenced by the
+// generated constants, the selected field names (like Flex of Tailwind.Flex) are collected as well. Generated
+// files are ignored, otherwise the generated constants would use every class.
+func ScanGoFiles(dirs []string) (map[string]bool, error) {
+	used := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() || !strings.HasSuffix(path, ".go") {
+				return nil
+			}
+
+			file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+			if err != nil {
+				return fmt.Errorf("unable to parse go file: %w", err)
+			}
+
+			if isGenerated(file) {
+				return nil
+			}
+
+			ast.Inspect(file, func(node ast.Node) bool {
+				switch n := node.(type) {
+				case *ast.BasicLit:
+					if n.Kind != token.STRING {
+						return true
+					}
+
+					text, err := strconv.Unquote(n.Value)
+					if err != nil {
+						return true
+					}
+
+					for _, name := range strings.Fields(text) {
+						if regexClassName.MatchString(name) {
+							used[name] = true
+						}
+					}
+				case *ast.SelectorExpr:
+					used[n.Sel.Name] = true
+				}
+
+				return true
+			})
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan go files: %s: %w", dir, err)
+		}
+	}
+
+	return used, nil
+}
+
+// isGenerated returns true, if the file contains a "Code generated ... DO NOT EDIT." comment before its package clause.
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			return false
+		}
+
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated ") && strings.HasSuffix(comment.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Purge returns only those classes, whose class name or Go identifier is contained in used.
+func Purge(allClasses map[string]string, used map[string]bool) map[string]string {
+	res := map[string]string{}
+	for identifier, className := range allClasses {
+		if used[className] || used[identifier] {
+			res[identifier] = className
+		}
+	}
+
+	return res
+}
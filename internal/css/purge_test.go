@@ -0,0 +1,44 @@
+package css
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	css := []byte(".flex {\n}\n.items-center {\n}\n.md\\:px-4 {\n}\n.hidden {\n}\n.text-red-500 {\n}\n")
+	all := ParseClassNames(css)
+
+	generated := &bytes.Buffer{}
+	if err := WriteClassNames(all, generated, "app", "Tailwind"); err != nil {
+		t.Fatal(err)
+	}
+
+	src := "package app\n\nvar a = \"flex items-center\"\nvar b = \"md:px-4\"\nvar c = Tailwind.TextRed500\n"
+	files := map[string][]byte{"app.go": []byte(src), "tailwind.go": generated.Bytes()}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	used, err := ScanGoFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged := Purge(all, used)
+	if len(purged) != 4 || purged["Hidden"] != "" || purged["MdPx4"] != "md:px-4" || purged["TextRed500"] == "" {
+		t.Fatalf("unexpected purge result: %v", purged)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package css
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// VerifyTemplateClasses parses all .gohtml files below templateDir and returns the string literals of their
+// template actions, which look like css classes but are neither a class name nor a Go identifier of the given
+// constants, as created by ParseClassNames. Each entry has the form <file>: <class>, where file is relative to
+// templateDir. Files which cannot be parsed are ignored, because applying them fails anyway.
+func VerifyTemplateClasses(templateDir string, constants map[string]string) []string {
+	known := map[string]bool{}
+	for identifier, className := range constants {
+		known[identifier] = true
+		known[className] = true
+	}
+
+	unknown := map[string]bool{}
+	_ = filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.ToLower(filepath.Ext(path)) != ".gohtml" {
+			return nil
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+
+		tree := parse.New(path)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(string(buf), "", "", map[string]*parse.Tree{}); err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			rel = path
+		}
+
+		for _, literal := range stringLiterals(tree.Root) {
+			for _, name := range classNames(literal) {
+				if !known[name] {
+					unknown[filepath.ToSlash(rel)+": "+name] = true
+				}
+			}
+		}
+
+		return nil
+	})
+
+	res := make([]string, 0, len(unknown))
+	for entry := range unknown {
+		res = append(res, entry)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// classNames returns the space separated names of the literal, if all of them look like css classes.
+func classNames(literal string) []string {
+	names := strings.Fields(literal)
+	for _, name := range names {
+		if !regexClassName.MatchString(name) {
+			return nil
+		}
+	}
+
+	return names
+}
+
+// stringLiterals collects the string literals of all actions below the given node.
+func stringLiterals(node parse.Node) []string {
+	var res []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, child := range n.Nodes {
+			res = append(res, stringLiterals(child)...)
+		}
+	case *parse.ActionNode:
+		res = append(res, stringLiterals(n.Pipe)...)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, cmd := range n.Cmds {
+			res = append(res, stringLiterals(cmd)...)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			res = append(res, stringLiterals(arg)...)
+		}
+	case *parse.StringNode:
+		res = append(res, n.Text)
+	case *parse.IfNode:
+		res = append(res, branchLiterals(&n.BranchNode)...)
+	case *parse.RangeNode:
+		res = append(res, branchLiterals(&n.BranchNode)...)
+	case *parse.WithNode:
+		res = append(res, branchLiterals(&n.BranchNode)...)
+	case *parse.TemplateNode:
+		res = append(res, stringLiterals(n.Pipe)...)
+	}
+
+	return res
+}
+
+// branchLiterals collects the string literals of the condition and both branches.
+func branchLiterals(n *parse.BranchNode) []string {
+	res := stringLiterals(n.Pipe)
+	res = append(res, stringLiterals(n.List)...)
+
+	return append(res, stringLiterals(n.ElseList)...)
+}
@@ -0,0 +1,43 @@
+package css
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyTemplateClasses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	constants := ParseClassNames([]byte(".flex {\n}\n.text-red-500 {\n}\n"))
+	files := map[string]string{
+		"index.gohtml":     `<div class="{{if .HasError}}{{"text-red-500 flexx"}}{{else}}{{"flex"}}{{end}}">{{humanize .WasmSizeBytes}}</div>`,
+		"sub/page.gohtml":  `{{with .Extra}}{{printf "%s" "bg-blue-500"}}{{end}}{{"not a class!"}}`,
+		"broken.gohtml":    `{{if "unknown-class"}}`,
+		"ignored.gocss":    `{{"unknown-class"}}`,
+		"sub/known.gohtml": `{{"TextRed500"}}`,
+	}
+
+	for name, content := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"index.gohtml: flexx", "sub/page.gohtml: bg-blue-500"}
+	if got := VerifyTemplateClasses(dir, constants); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v but got %v", want, got)
+	}
+}
@@ -1,17 +1,31 @@
 package deploy
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/golangee/gotrino-make/internal/fs/local"
 	"github.com/golangee/gotrino-make/internal/fs/sftp"
+	"github.com/golangee/gotrino-make/internal/hashtree"
 	"github.com/golangee/log"
 	"github.com/worldiety/go-tip/1.16/io/fs"
+	"golang.org/x/sync/errgroup"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
 var Debug = false
 
+// ManifestName is the file in the root of dst, which contains the hashes of all files uploaded by the last Sync.
+// Files with this name are never removed as extra files.
+const ManifestName = ".gotrino-manifest.json"
+
 type MkdirAll interface {
 	MkdirAll(name string) error
 }
@@ -24,114 +38,508 @@ type RemoveAll interface {
 	RemoveAll(name string) error
 }
 
-func SyncSFTP(remoteDir, localDir string, host, user, password string, port int) error {
-	sftpFS, err := sftp.Connect(sftp.Options{
-		Host:     host,
-		Port:     port,
-		User:     user,
-		Password: password,
-	})
+type Chmod interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+// An UploadFunc transfers the file with the given name from src to dst.
+type UploadFunc func(dst, src fs.FS, name string) error
 
+// SyncOptions configure the synchronization.
+type SyncOptions struct {
+	// Upload transfers a single file. If nil, CopyFile is used.
+	Upload UploadFunc
+	// OnProgress is invoked after each file has been processed (uploaded, skipped or deleted). Total is the amount
+	// of files to process, as calculated before the synchronization has been started. May be nil.
+	OnProgress func(uploaded, total int, currentFile string)
+	// PreservePermissions applies the permission bits of each uploaded source file to its destination, if
+	// dst implements Chmod. A failing chmod is only logged as a warning. The command line default is true.
+	PreservePermissions bool
+	// Concurrency is the amount of parallel uploads. The sftp client multiplexes all of them over the same
+	// connection, which hides the round-trip latency for many small files. Values below 2 upload sequentially.
+	Concurrency int
+	// ExcludeGlobs are path.Match patterns of src files, which are not uploaded but counted as skipped. See
+	// Excluded for the matching rules.
+	ExcludeGlobs []string
+}
+
+// A SyncSummary reports what a Sync has done.
+type SyncSummary struct {
+	Uploaded      int           // Uploaded is the amount of transferred files.
+	Skipped       int           // Skipped is the amount of files, which are unchanged since the last Sync.
+	Deleted       int           // Deleted is the amount of removed extra files and directories.
+	BytesUploaded int64         // BytesUploaded is the total size of all transferred files.
+	Duration      time.Duration // Duration includes the planning of the Sync.
+}
+
+// String returns a single line for the console.
+func (s SyncSummary) String() string {
+	return fmt.Sprintf("uploaded %d files (%d bytes), skipped %d, deleted %d in %v",
+		s.Uploaded, s.BytesUploaded, s.Skipped, s.Deleted, s.Duration.Round(time.Millisecond))
+}
+
+func SyncSFTP(remoteDir, localDir string, opts sftp.Options, syncOpts SyncOptions) (SyncSummary, error) {
+	sftpFS, err := sftp.ConnectWithRetry(opts, sftp.DefaultConnectRetries, sftp.DefaultConnectDelay)
 	if err != nil {
-		return fmt.Errorf("unable to connect sftp FS: %w", err)
+		return SyncSummary{}, fmt.Errorf("unable to connect sftp FS: %w", err)
 	}
 
+	defer sftpFS.Close()
+
 	dst, err := fs.Sub(sftpFS, remoteDir)
 	if err != nil {
-		return fmt.Errorf("unable to sub dst: %w", err)
+		return SyncSummary{}, fmt.Errorf("unable to sub dst: %w", err)
 	}
 
 	src, err := fs.Sub(local.Get(), localDir)
 	if err != nil {
-		return fmt.Errorf("unable to sub src: %w", err)
+		return SyncSummary{}, fmt.Errorf("unable to sub src: %w", err)
+	}
+
+	return Sync(dst.(fs.ReadDirFS), src.(fs.ReadDirFS), syncOpts)
+}
+
+// A SyncPlan contains the operations of a Sync in the order of their execution. All names are slash separated
+// and relative to the root of dst.
+type SyncPlan struct {
+	Mkdirs   []string          // Mkdirs are created first, parents before their children.
+	Uploads  []string          // Uploads are transferred second, after all directories exist.
+	Skipped  []string          // Skipped files are unchanged since the last Sync.
+	Excluded []string          // Excluded files match an exclude glob and are neither uploaded nor removed.
+	Removes  []string          // Removes are deleted last, files before their parent directories.
+	Manifest map[string]string // Manifest contains the hex encoded hashes of all src files for the next Sync.
+}
+
+// Total returns the amount of files to process, which is reported by SyncOptions.OnProgress.
+func (p SyncPlan) Total() int {
+	return len(p.Uploads) + len(p.Skipped) + len(p.Excluded) + len(p.Removes)
+}
+
+// PlanSync compares src with dst and the manifest of the last Sync without modifying anything. Src files, which
+// are Excluded by any of the given globs, are neither uploaded nor removed from dst.
+func PlanSync(dst, src fs.ReadDirFS, excludeGlobs ...string) (SyncPlan, error) {
+	for _, glob := range excludeGlobs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return SyncPlan{}, fmt.Errorf("invalid exclude glob '%s': %w", glob, err)
+		}
+	}
+
+	tree, err := hashtree.HashFS(src)
+	if err != nil {
+		return SyncPlan{}, fmt.Errorf("unable to hash src: %w", err)
+	}
+
+	plan := SyncPlan{Manifest: map[string]string{}}
+	if err := plan.collect(dst, src, ".", tree, readManifest(dst), excludeGlobs); err != nil {
+		return SyncPlan{}, err
 	}
 
-	return Sync(dst.(fs.ReadDirFS), src.(fs.ReadDirFS))
+	return plan, nil
 }
 
-func Sync(dst, src fs.ReadDirFS) error {
-	srcFiles, err := src.ReadDir(".")
+// collect appends the operations for the given directory and descends into all sub directories.
+func (p *SyncPlan) collect(dst, src fs.ReadDirFS, dir string, node *hashtree.Node, manifest map[string]string, excludeGlobs []string) error {
+	srcFiles, err := src.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 
+	// a missing dst directory has no files
+	dstFiles, _ := dst.ReadDir(dir)
+
 	for _, file := range srcFiles {
+		name := path.Join(dir, file.Name())
+		child := node.Find(file.Name())
+		if child == nil {
+			return fmt.Errorf("unable to find hash of: %s", name)
+		}
+
+		if Excluded(excludeGlobs, name) {
+			p.Excluded = append(p.Excluded, filesBelow(name, child)...)
+			continue
+		}
+
 		if file.IsDir() {
-			if Debug {
-				log.Println(fmt.Sprintf("copy dir: %s", file.Name()))
+			p.Mkdirs = append(p.Mkdirs, name)
+			if err := p.collect(dst, src, name, child, manifest, excludeGlobs); err != nil {
+				return err
 			}
 
-			if err := dst.(MkdirAll).MkdirAll(file.Name()); err != nil {
-				return fmt.Errorf("unable to ensure directory in dst: %w", err)
-			}
+			continue
+		}
+
+		hash := hex.EncodeToString(child.Hash[:])
+		p.Manifest[name] = hash
+
+		if manifest[name] == hash && containsName(dstFiles, file.Name()) {
+			p.Skipped = append(p.Skipped, name)
+		} else {
+			p.Uploads = append(p.Uploads, name)
+		}
+	}
+
+	for _, file := range dstFiles {
+		if containsName(srcFiles, file.Name()) || (dir == "." && file.Name() == ManifestName) {
+			continue
+		}
+
+		if err := p.collectRemoves(dst, path.Join(dir, file.Name()), file.IsDir()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filesBelow returns the given name of a file or the names of all files below the given directory.
+func filesBelow(name string, node *hashtree.Node) []string {
+	if !node.Mode.IsDir() {
+		return []string{name}
+	}
 
-			subSrc, err := fs.Sub(src, file.Name())
-			if err != nil {
-				return fmt.Errorf("unable to subroot src: %w", err)
+	var res []string
+	for _, child := range node.Children {
+		res = append(res, filesBelow(path.Join(name, child.Name), child)...)
+	}
+
+	return res
+}
+
+// Excluded returns true, if the slash separated name, its base name or any of its parent directories matches
+// one of the path.Match globs. A glob like testdata/** matches everything below testdata.
+func Excluded(globs []string, name string) bool {
+	for _, glob := range globs {
+		dirGlob := strings.TrimSuffix(glob, "/**")
+		if ok, _ := path.Match(glob, path.Base(name)); ok {
+			return true
+		}
+
+		for dir := name; dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(glob, dir); ok {
+				return true
 			}
 
-			subDst, err := fs.Sub(dst, file.Name())
-			if err != nil {
-				return fmt.Errorf("unable to subroot dst: %w", err)
+			if ok, _ := path.Match(dirGlob, dir); ok && dirGlob != glob {
+				return true
 			}
+		}
+	}
+
+	return false
+}
+
+// collectRemoves appends the given extra file. The content of an extra directory is appended before the directory.
+func (p *SyncPlan) collectRemoves(dst fs.ReadDirFS, name string, isDir bool) error {
+	if isDir {
+		files, err := dst.ReadDir(name)
+		if err != nil {
+			return fmt.Errorf("unable to list extra directory: %s: %w", name, err)
+		}
 
-			if err := Sync(subDst.(fs.ReadDirFS), subSrc.(fs.ReadDirFS)); err != nil {
+		for _, file := range files {
+			if err := p.collectRemoves(dst, path.Join(name, file.Name()), file.IsDir()); err != nil {
 				return err
 			}
-		} else {
-			if Debug {
-				log.Println(fmt.Sprintf("copy file: %s", file.Name()))
-			}
+		}
+	}
 
-			dstFile, err := dst.(OpenFile).OpenFile(file.Name(), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("unable to write dst file: %w", err)
-			}
+	p.Removes = append(p.Removes, name)
 
-			srcFile, err := src.Open(file.Name())
-			if err != nil {
-				_ = dstFile.Close()
-				return fmt.Errorf("unable to open src file: %w", err)
-			}
+	return nil
+}
 
-			if _, err := io.Copy(dstFile.(io.Writer), srcFile); err != nil {
-				_ = srcFile.Close()
-				_ = dstFile.Close()
-				return fmt.Errorf("unable to copy src to dst: %w", err)
-			}
+// Sync copies all files from src into dst and removes all extra files from dst. Files whose content hash equals
+// the hash in the manifest of the last Sync are not uploaded again. The operations are executed in the phases of
+// the SyncPlan, so that no file is uploaded before its directory exists. Afterwards, the manifest is updated.
+// The summary counts all operations, which have been completed, even if an error is returned.
+func Sync(dst, src fs.ReadDirFS, opts SyncOptions) (SyncSummary, error) {
+	start := time.Now()
+	s := &syncer{opts: opts}
+	summary, err := s.sync(dst, src)
+	summary.Duration = time.Since(start)
+
+	return summary, err
+}
+
+// sync executes the phases of the SyncPlan.
+func (s *syncer) sync(dst, src fs.ReadDirFS) (SyncSummary, error) {
+	plan, err := PlanSync(dst, src, s.opts.ExcludeGlobs...)
+	if err != nil {
+		return s.summary, fmt.Errorf("unable to plan sync: %w", err)
+	}
+
+	s.total = plan.Total()
+
+	if s.opts.Concurrency > 1 {
+		s.group, s.ctx = errgroup.WithContext(context.Background())
+		s.group.SetLimit(s.opts.Concurrency)
+	}
+
+	if err := s.mkdirs(dst, plan.Mkdirs); err != nil {
+		return s.summary, err
+	}
 
-			_ = srcFile.Close()
-			_ = dstFile.Close()
+	err = s.uploads(dst, src, append(plan.Excluded, plan.Skipped...), plan.Uploads)
+	if s.group != nil {
+		// the first upload error cancels the scheduling, so prefer it over the cancellation
+		if uploadErr := s.group.Wait(); uploadErr != nil {
+			err = uploadErr
 		}
+	}
+
+	if err != nil {
+		return s.summary, err
+	}
 
+	if err := s.removes(dst, plan.Removes); err != nil {
+		return s.summary, err
 	}
 
-	// check extra files in dst
-	dstFiles, err := dst.ReadDir(".")
+	return s.summary, writeManifest(dst, plan.Manifest)
+}
+
+// readManifest returns the file hashes of the last Sync. A missing or broken manifest is just empty.
+func readManifest(dst fs.FS) map[string]string {
+	manifest := map[string]string{}
+	f, err := dst.Open(ManifestName)
 	if err != nil {
-		return err
+		return manifest
 	}
 
-	for _, file := range dstFiles {
-		has := false
-		for _, srcFile := range srcFiles {
-			if srcFile.Name() == file.Name() {
-				has = true
-				break
-			}
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(f)
+	if err == nil {
+		err = json.Unmarshal(buf, &manifest)
+	}
+
+	if err != nil {
+		if Debug {
+			log.Println(fmt.Sprintf("ignoring unreadable manifest: %v", err))
 		}
 
-		if !has {
-			if Debug {
-				log.Println(fmt.Sprintf("removing extra file: %s, isDir=%v", file.Name(), file.IsDir()))
-			}
+		return map[string]string{}
+	}
 
-			if err := dst.(RemoveAll).RemoveAll(file.Name()); err != nil {
-				return fmt.Errorf("unable to remove: %s: %w", file.Name(), err)
-			}
+	return manifest
+}
+
+// writeManifest replaces the manifest in dst.
+func writeManifest(dst fs.FS, manifest map[string]string) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal manifest: %w", err)
+	}
+
+	f, err := dst.(OpenFile).OpenFile(ManifestName, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+
+	if _, err := f.(io.Writer).Write(buf); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to close manifest: %w", err)
+	}
+
+	return nil
+}
+
+// containsName returns true, if any entry has the given name.
+func containsName(entries []fs.DirEntry, name string) bool {
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return true
 		}
+	}
+
+	return false
+}
+
+// syncer tracks the progress of a Sync.
+type syncer struct {
+	opts      SyncOptions
+	total     int
+	processed int
+	group     *errgroup.Group // group runs the uploads, if SyncOptions.Concurrency is larger than 1.
+	ctx       context.Context // ctx is cancelled by the group after the first failed upload.
+	lock      sync.Mutex      // lock serializes the progress of concurrent uploads.
+	summary   SyncSummary     // summary is guarded by lock.
+}
 
+// progress notifies about a processed file and counts it by the given func.
+func (s *syncer) progress(name string, count func(summary *SyncSummary)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	count(&s.summary)
+	s.processed++
+	if s.opts.OnProgress != nil {
+		s.opts.OnProgress(s.processed, s.total, name)
+	}
+}
+
+// CopyFile is the default UploadFunc and transfers the entire file.
+func CopyFile(dst, src fs.FS, name string) error {
+	dstFile, err := dst.(OpenFile).OpenFile(name, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write dst file: %w", err)
+	}
+
+	srcFile, err := src.Open(name)
+	if err != nil {
+		_ = dstFile.Close()
+		return fmt.Errorf("unable to open src file: %w", err)
+	}
+
+	if _, err := io.Copy(dstFile.(io.Writer), srcFile); err != nil {
+		_ = srcFile.Close()
+		_ = dstFile.Close()
+		return fmt.Errorf("unable to copy src to dst: %w", err)
+	}
+
+	_ = srcFile.Close()
+	_ = dstFile.Close()
+
+	return nil
+}
+
+// mkdirs creates the given directories in order.
+func (s *syncer) mkdirs(dst fs.FS, dirs []string) error {
+	for _, dir := range dirs {
+		if Debug {
+			log.Println(fmt.Sprintf("copy dir: %s", dir))
+		}
+
+		if err := dst.(MkdirAll).MkdirAll(dir); err != nil {
+			return fmt.Errorf("unable to ensure directory in dst: %w", err)
+		}
 	}
 
 	return nil
 }
+
+// uploads reports the skipped files and transfers all other files.
+func (s *syncer) uploads(dst, src fs.FS, skipped, uploads []string) error {
+	for _, name := range skipped {
+		if Debug {
+			log.Println(fmt.Sprintf("unchanged file: %s", name))
+		}
+
+		s.progress(name, func(summary *SyncSummary) { summary.Skipped++ })
+	}
+
+	for _, name := range uploads {
+		if Debug {
+			log.Println(fmt.Sprintf("copy file: %s", name))
+		}
+
+		// uploads are invoked relative to the directory of the file, so that e.g. the rsync checksums are
+		// requested for the plain name
+		dir := path.Dir(name)
+		subDst, err := fs.Sub(dst, dir)
+		if err != nil {
+			return fmt.Errorf("unable to subroot dst: %w", err)
+		}
+
+		subSrc, err := fs.Sub(src, dir)
+		if err != nil {
+			return fmt.Errorf("unable to subroot src: %w", err)
+		}
+
+		if err := s.upload(subDst, subSrc, dir, path.Base(name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removes deletes the given files and directories in order.
+func (s *syncer) removes(dst fs.FS, names []string) error {
+	for _, name := range names {
+		if Debug {
+			log.Println(fmt.Sprintf("removing extra file: %s", name))
+		}
+
+		if err := dst.(RemoveAll).RemoveAll(name); err != nil {
+			return fmt.Errorf("unable to remove: %s: %w", name, err)
+		}
+
+		s.progress(name, func(summary *SyncSummary) { summary.Deleted++ })
+	}
+
+	return nil
+}
+
+// upload transfers the named file of the given directory, either directly or by the worker pool.
+func (s *syncer) upload(dst, src fs.FS, dir, name string) error {
+	if s.group == nil {
+		return s.uploadFile(dst, src, dir, name)
+	}
+
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	s.group.Go(func() error {
+		return s.uploadFile(dst, src, dir, name)
+	})
+
+	return nil
+}
+
+// uploadFile transfers the named file of the given directory and notifies about the progress.
+func (s *syncer) uploadFile(dst, src fs.FS, dir, name string) error {
+	upload := s.opts.Upload
+	if upload == nil {
+		upload = CopyFile
+	}
+
+	if err := upload(dst, src, name); err != nil {
+		return err
+	}
+
+	if s.opts.PreservePermissions {
+		preservePermissions(dst, src, name)
+	}
+
+	var size int64
+	if info, err := fs.Stat(src, name); err == nil {
+		size = info.Size()
+	}
+
+	if Debug {
+		log.Println(fmt.Sprintf("uploaded file: %s (%d bytes)", path.Join(dir, name), size))
+	}
+
+	s.progress(path.Join(dir, name), func(summary *SyncSummary) {
+		summary.Uploaded++
+		summary.BytesUploaded += size
+	})
+
+	return nil
+}
+
+// preservePermissions applies the permission bits of the source file to the destination file. Failures are
+// only logged, because not every server allows to change modes.
+func preservePermissions(dst, src fs.FS, name string) {
+	chmod, ok := dst.(Chmod)
+	if !ok {
+		return
+	}
+
+	info, err := fs.Stat(src, name)
+	if err != nil {
+		log.Println(fmt.Sprintf("warning: unable to stat '%s': %v", name, err))
+		return
+	}
+
+	mode := os.FileMode(info.Mode().Perm())
+	if err := chmod.Chmod(name, mode); err != nil {
+		log.Println(fmt.Sprintf("warning: unable to chmod '%s' to %v: %v", name, mode, err))
+	}
+}
@@ -0,0 +1,445 @@
+package deploy_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golangee/gotrino-make/internal/deploy"
+	"github.com/worldiety/go-tip/1.16/io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncProgress(t *testing.T) {
+	src := newMemFS()
+	src.files["index.html"] = []byte("<html></html>")
+	src.files["app.wasm"] = []byte("wasm")
+	src.files["css/main.css"] = []byte("body{}")
+	src.files["css/fonts/a.woff"] = []byte("font")
+
+	dst := newMemFS()
+	dst.files["index.html"] = []byte("old")
+	dst.files["stale.js"] = []byte("stale")
+	dst.files["css/old.css"] = []byte("old")
+
+	var calls []int
+	var names []string
+	lastTotal := -1
+	_, err := deploy.Sync(dst, src, deploy.SyncOptions{OnProgress: func(uploaded, total int, currentFile string) {
+		calls = append(calls, uploaded)
+		names = append(names, currentFile)
+		if lastTotal != -1 && lastTotal != total {
+			t.Fatalf("total changed from %d to %d", lastTotal, total)
+		}
+
+		lastTotal = total
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 uploads and 2 deletions
+	if lastTotal != 6 || len(calls) != 6 {
+		t.Fatalf("expected 6 calls with total 6 but got %d calls with total %d: %v", len(calls), lastTotal, names)
+	}
+
+	for i, n := range calls {
+		if n != i+1 {
+			t.Fatalf("expected monotonic progress but got %v", calls)
+		}
+	}
+
+	sort.Strings(names)
+	want := "app.wasm,css/fonts/a.woff,css/main.css,css/old.css,index.html,stale.js"
+	if got := strings.Join(names, ","); got != want {
+		t.Fatalf("expected %s but got %s", want, got)
+	}
+
+	if string(dst.files["index.html"]) != "<html></html>" || dst.files["stale.js"] != nil {
+		t.Fatalf("unexpected dst state: %v", dst.files)
+	}
+}
+
+func TestSyncNilProgress(t *testing.T) {
+	src := newMemFS()
+	src.files["a.txt"] = []byte("a")
+
+	if _, err := deploy.Sync(newMemFS(), src, deploy.SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncSkipsUnchanged(t *testing.T) {
+	src := newMemFS()
+	src.files["index.html"] = []byte("<html></html>")
+	src.files["css/main.css"] = []byte("body{}")
+
+	var uploads []string
+	opts := deploy.SyncOptions{Upload: func(dst, src fs.FS, name string) error {
+		uploads = append(uploads, name)
+		return deploy.CopyFile(dst, src, name)
+	}}
+
+	dst := newMemFS()
+	if _, err := deploy.Sync(dst, src, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploads) != 2 || dst.files[deploy.ManifestName] == nil {
+		t.Fatalf("expected 2 uploads and a manifest but got %v: %v", uploads, dst.files)
+	}
+
+	uploads = nil
+	src.files["css/main.css"] = []byte("body{color:red}")
+	if _, err := deploy.Sync(dst, src, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploads) != 1 || uploads[0] != "main.css" || string(dst.files["css/main.css"]) != "body{color:red}" {
+		t.Fatalf("expected only main.css to be uploaded but got %v", uploads)
+	}
+}
+
+func TestSyncSummary(t *testing.T) {
+	src := newMemFS()
+	src.files["index.html"] = []byte("<html></html>")
+	src.files["css/main.css"] = []byte("body{}")
+
+	dst := newMemFS()
+	dst.files["stale.js"] = []byte("stale")
+	if _, err := deploy.Sync(dst, src, deploy.SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	src.files["index.html"] = []byte("<html><body></body></html>")
+	dst.files["old/a.css"] = []byte("a")
+	summary, err := deploy.Sync(dst, src, deploy.SyncOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := deploy.SyncSummary{Uploaded: 1, Skipped: 1, Deleted: 2, BytesUploaded: 26}
+	summary.Duration = 0
+	if summary != want {
+		t.Fatalf("expected %+v but got %+v", want, summary)
+	}
+}
+
+func TestSyncExclude(t *testing.T) {
+	src := newMemFS()
+	src.files["index.html"] = []byte("<html></html>")
+	src.files["app.js.map"] = []byte("{}")
+	src.files[".DS_Store"] = []byte("x")
+	src.files["css/main.css.map"] = []byte("{}")
+	src.files["testdata/a/b.json"] = []byte("{}")
+
+	dst := newMemFS()
+	dst.files[".DS_Store"] = []byte("remote")
+	summary, err := deploy.Sync(dst, src, deploy.SyncOptions{ExcludeGlobs: []string{"*.map", ".DS_Store", "testdata/**"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Uploaded != 1 || summary.Skipped != 4 || summary.Deleted != 0 {
+		t.Fatalf("expected 1 upload and 4 skipped files but got %+v", summary)
+	}
+
+	if dst.files["app.js.map"] != nil || dst.files["testdata/a/b.json"] != nil || string(dst.files[".DS_Store"]) != "remote" {
+		t.Fatalf("unexpected dst state: %v", dst.files)
+	}
+
+	if _, err := deploy.Sync(dst, src, deploy.SyncOptions{ExcludeGlobs: []string{"["}}); err == nil {
+		t.Fatal("expected an invalid glob to fail")
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	tests := []struct {
+		glob string
+		name string
+		want bool
+	}{
+		{glob: "*.map", name: "js/app.js.map", want: true},
+		{glob: "test-*.html", name: "test-a.html", want: true},
+		{glob: "testdata/**", name: "testdata/a/b.json", want: true},
+		{glob: "testdata/*", name: "testdata/a/b.json", want: true},
+		{glob: "testdata/**", name: "data/testdata.json", want: false},
+		{glob: "*.map", name: "app.js", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := deploy.Excluded([]string{tt.glob}, tt.name); got != tt.want {
+			t.Fatalf("%s %s: expected %v but got %v", tt.glob, tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestSyncConcurrency simulates the round-trip latency of a remote server by a slow upload.
+func TestSyncConcurrency(t *testing.T) {
+	src := newMemFS()
+	for i := 0; i < 100; i++ {
+		src.files[fmt.Sprintf("%d.json", i)] = []byte("{}")
+	}
+
+	// overlapping returns the maximum amount of concurrent uploads. The first uploads wait for each other, so that
+	// they must overlap, if the concurrency is honored.
+	overlapping := func(concurrency int) int {
+		var lock sync.Mutex
+		running, max, uploads := 0, 0, 0
+		all := make(chan struct{})
+		opts := deploy.SyncOptions{Concurrency: concurrency, Upload: func(dst, src fs.FS, name string) error {
+			lock.Lock()
+			running++
+			uploads++
+			if running > max {
+				max = running
+			}
+
+			if uploads == concurrency {
+				close(all)
+			}
+
+			lock.Unlock()
+
+			// just a guard against a deadlock, if the uploads are not executed concurrently
+			select {
+			case <-all:
+			case <-time.After(10 * time.Second):
+				return fmt.Errorf("expected %d concurrent uploads", concurrency)
+			}
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+
+			return nil
+		}}
+
+		// the uploads do not write, so keep dst non-empty for the memFS
+		dst := newMemFS()
+		dst.files["stale.txt"] = []byte("stale")
+
+		if _, err := deploy.Sync(dst, src, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if uploads != 100 {
+			t.Fatalf("expected 100 uploads but got %d", uploads)
+		}
+
+		return max
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		if got := overlapping(concurrency); got != concurrency {
+			t.Fatalf("expected %d overlapping uploads but got %d", concurrency, got)
+		}
+	}
+}
+
+func TestSyncConcurrencyError(t *testing.T) {
+	src := newMemFS()
+	for i := 0; i < 10; i++ {
+		src.files[fmt.Sprintf("%d.txt", i)] = []byte("x")
+	}
+
+	dst := newMemFS()
+	dst.files["stale.txt"] = []byte("stale")
+	opts := deploy.SyncOptions{Concurrency: 4, Upload: func(dst, src fs.FS, name string) error {
+		return fmt.Errorf("upload failed")
+	}}
+
+	if _, err := deploy.Sync(dst, src, opts); err == nil || err.Error() != "upload failed" {
+		t.Fatalf("expected the upload error but got %v", err)
+	}
+
+	if dst.files[deploy.ManifestName] != nil {
+		t.Fatal("expected no manifest after a failed upload")
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	src := newMemFS()
+	src.files["index.html"] = []byte("<html></html>")
+	src.files["css/fonts/a.woff"] = []byte("font")
+
+	dst := newMemFS()
+	dst.files["old/a.css"] = []byte("a")
+	dst.files["old/b/c.css"] = []byte("c")
+
+	plan, err := deploy.PlanSync(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(plan.Mkdirs, ","); got != "css,css/fonts" {
+		t.Fatalf("expected parents before children but got %s", got)
+	}
+
+	if got := strings.Join(plan.Uploads, ","); got != "css/fonts/a.woff,index.html" {
+		t.Fatalf("unexpected uploads %s", got)
+	}
+
+	if got := strings.Join(plan.Removes, ","); got != "old/a.css,old/b/c.css,old/b,old" {
+		t.Fatalf("expected files before their directories but got %s", got)
+	}
+
+	if plan.Total() != 6 || len(dst.files) != 2 {
+		t.Fatalf("expected a total of 6 without modifications but got %d: %v", plan.Total(), dst.files)
+	}
+}
+
+// memFS is a trivial in-memory filesystem, which shares its files with all sub filesystems.
+type memFS struct {
+	prefix string
+	files  map[string][]byte
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string][]byte{}}
+}
+
+func (m *memFS) abs(name string) string {
+	return path.Join(m.prefix, name)
+}
+
+func (m *memFS) Sub(dir string) (fs.FS, error) {
+	return &memFS{prefix: m.abs(dir), files: m.files}, nil
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	return &memFile{parent: m, name: m.abs(name)}, nil
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	return &memFile{parent: m, name: m.abs(name)}, nil
+}
+
+func (m *memFS) MkdirAll(name string) error {
+	return nil
+}
+
+func (m *memFS) RemoveAll(name string) error {
+	name = m.abs(name)
+	for key := range m.files {
+		if key == name || strings.HasPrefix(key, name+"/") {
+			delete(m.files, key)
+		}
+	}
+
+	return nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir := m.abs(name)
+	seen := map[string]bool{}
+	var res []fs.DirEntry
+	for key := range m.files {
+		rel := key
+		if dir != "." && dir != "" {
+			if !strings.HasPrefix(key, dir+"/") {
+				continue
+			}
+
+			rel = key[len(dir)+1:]
+		}
+
+		segments := strings.SplitN(rel, "/", 2)
+		if seen[segments[0]] {
+			continue
+		}
+
+		seen[segments[0]] = true
+		res = append(res, memInfo{name: segments[0], dir: len(segments) > 1})
+	}
+
+	if len(res) == 0 {
+		return nil, fs.ErrNotExist
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+
+	return res, nil
+}
+
+type memFile struct {
+	parent *memFS
+	name   string
+	buf    bytes.Buffer
+	reader *bytes.Reader
+	write  bool
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return memInfo{name: path.Base(f.name), size: int64(len(f.parent.files[f.name]))}, nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.reader == nil {
+		f.reader = bytes.NewReader(f.parent.files[f.name])
+	}
+
+	return f.reader.Read(b)
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.write = true
+	return f.buf.Write(b)
+}
+
+func (f *memFile) Close() error {
+	if f.write {
+		f.parent.files[f.name] = f.buf.Bytes()
+	}
+
+	return nil
+}
+
+type memInfo struct {
+	name string
+	dir  bool
+	size int64
+}
+
+func (i memInfo) Name() string {
+	return i.name
+}
+
+func (i memInfo) Size() int64 {
+	return i.size
+}
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir
+	}
+
+	return 0
+}
+
+func (i memInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i memInfo) IsDir() bool {
+	return i.dir
+}
+
+func (i memInfo) Sys() interface{} {
+	return nil
+}
+
+func (i memInfo) Type() fs.FileMode {
+	return i.Mode()
+}
+
+func (i memInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
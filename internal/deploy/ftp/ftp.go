@@ -0,0 +1,471 @@
+// Package ftp synchronizes a local directory into a remote directory using the file transfer protocol (FTP)
+// with TLS. In contrast to a plain upload, files which only exist remotely are removed.
+package ftp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/golangee/gotrino-make/internal/deploy"
+	"github.com/golangee/log"
+	"gopkg.in/dutchcoders/goftp.v1"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Debug enables verbose logging of all ftp operations.
+var Debug = false
+
+// DefaultPort is the usual ftp port.
+const DefaultPort = 21
+
+// FTPOptions mirrors sftp.Options for ftp connections.
+type FTPOptions struct {
+	Host     string
+	Port     int // Port default is 21.
+	User     string
+	Password string
+	// InsecureSkipVerify accepts any certificate of the server, which must be considered insecure.
+	InsecureSkipVerify bool
+}
+
+// RemoteFile is a parsed entry of a remote directory listing.
+type RemoteFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time // ModTime is the zero time, if the listing did not contain it.
+	IsDir   bool
+}
+
+// SyncFTP uploads all new or modified files from localDir into remoteDir and removes all remote files
+// and directories, which do not exist in localDir. Local files, which are deploy.Excluded by any of the given
+// globs, are neither uploaded nor removed from remoteDir but counted as skipped.
+func SyncFTP(opts FTPOptions, localDir, remoteDir string, excludeGlobs ...string) (deploy.SyncSummary, error) {
+	start := time.Now()
+	summary := deploy.SyncSummary{}
+	err := syncFTP(opts, localDir, remoteDir, excludeGlobs, &summary)
+	summary.Duration = time.Since(start)
+
+	return summary, err
+}
+
+func syncFTP(opts FTPOptions, localDir, remoteDir string, excludeGlobs []string, summary *deploy.SyncSummary) error {
+	for _, glob := range excludeGlobs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid exclude glob '%s': %w", glob, err)
+		}
+	}
+
+	conn, err := dial(opts)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if err := createDirectoryTree(conn, remoteDir); err != nil {
+		return err
+	}
+
+	if err := conn.Cwd(remoteDir); err != nil {
+		return fmt.Errorf("unable to change remote dir: %s: %w", remoteDir, err)
+	}
+
+	// all further operations use absolute paths, because the working directory changes while walking
+	absRemoteDir, err := conn.Pwd()
+	if err != nil {
+		return fmt.Errorf("unable to get remote working dir: %w", err)
+	}
+
+	return syncDir(conn, localDir, absRemoteDir, ".", excludeGlobs, summary)
+}
+
+// createDirectoryTree creates each missing component of the given remote directory. Nested directories below
+// it are created by syncDir. Afterwards, the working directory is the same as before.
+func createDirectoryTree(conn *goftp.FTP, remoteDir string) error {
+	base, err := conn.Pwd()
+	if err != nil {
+		return fmt.Errorf("unable to get remote working dir: %w", err)
+	}
+
+	current := base
+	if path.IsAbs(remoteDir) {
+		current = "/"
+	}
+
+	for _, name := range strings.Split(path.Clean(remoteDir), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		current = path.Join(current, name)
+		if err := conn.Cwd(current); err == nil {
+			continue
+		}
+
+		if Debug {
+			log.Println(fmt.Sprintf("create dir: %s", current))
+		}
+
+		if err := conn.Mkd(current); err != nil {
+			return fmt.Errorf("unable to create remote dir: %s: %w", current, err)
+		}
+	}
+
+	if err := conn.Cwd(base); err != nil {
+		return fmt.Errorf("unable to change remote dir: %s: %w", base, err)
+	}
+
+	return nil
+}
+
+func dial(opts FTPOptions) (*goftp.FTP, error) {
+	port := opts.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	conn, err := goftp.Connect(opts.Host + ":" + strconv.Itoa(port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect: %w", err)
+	}
+
+	if Debug {
+		log.Println("ftp connected to " + opts.Host)
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+		ClientAuth:         tls.RequestClientCert,
+	}
+
+	if !opts.InsecureSkipVerify {
+		config.ServerName = opts.Host
+	}
+
+	if err = conn.AuthTLS(config); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unable to AuthTLS: %w", err)
+	}
+
+	if err = conn.Login(opts.User, opts.Password); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unable to login: %w", err)
+	}
+
+	return conn, nil
+}
+
+// list returns the entries of the given absolute remote directory.
+func list(conn *goftp.FTP, remoteDir string) ([]RemoteFile, error) {
+	if err := conn.Cwd(remoteDir); err != nil {
+		return nil, fmt.Errorf("unable to change remote dir: %s: %w", remoteDir, err)
+	}
+
+	lines, err := conn.List("-a")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list remote dir: %s: %w", remoteDir, err)
+	}
+
+	return parseList(lines), nil
+}
+
+// syncDir synchronizes the given directories. The slash separated name is relative to the synchronized root
+// and is matched against the exclude globs.
+func syncDir(conn *goftp.FTP, localDir, remoteDir, name string, excludeGlobs []string, summary *deploy.SyncSummary) error {
+	remoteFiles, err := list(conn, remoteDir)
+	if err != nil {
+		return err
+	}
+
+	localFiles, err := ioutil.ReadDir(localDir)
+	if err != nil {
+		return fmt.Errorf("unable to read local dir: %s: %w", localDir, err)
+	}
+
+	for _, file := range localFiles {
+		localPath := filepath.Join(localDir, file.Name())
+		remotePath := path.Join(remoteDir, file.Name())
+		remote, exists := findRemoteFile(remoteFiles, file.Name())
+
+		if deploy.Excluded(excludeGlobs, path.Join(name, file.Name())) {
+			if Debug {
+				log.Println(fmt.Sprintf("excluded file: %s", remotePath))
+			}
+
+			summary.Skipped += countFiles(localPath)
+
+			continue
+		}
+
+		// a file has become a directory or vice versa
+		if exists && remote.IsDir != file.IsDir() {
+			if err := removeAll(conn, remotePath, remote.IsDir, summary); err != nil {
+				return err
+			}
+
+			exists = false
+		}
+
+		if file.IsDir() {
+			if !exists {
+				if Debug {
+					log.Println(fmt.Sprintf("create dir: %s", remotePath))
+				}
+
+				if err := conn.Mkd(remotePath); err != nil {
+					return fmt.Errorf("unable to create remote dir: %s: %w", remotePath, err)
+				}
+			}
+
+			if err := syncDir(conn, localPath, remotePath, path.Join(name, file.Name()), excludeGlobs, summary); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if exists && !isModified(file, remote) {
+			if Debug {
+				log.Println(fmt.Sprintf("unchanged file: %s", remotePath))
+			}
+
+			summary.Skipped++
+
+			continue
+		}
+
+		if err := upload(conn, localPath, remotePath); err != nil {
+			return err
+		}
+
+		summary.Uploaded++
+		summary.BytesUploaded += file.Size()
+	}
+
+	// check extra files in remote
+	for _, remote := range remoteFiles {
+		if !containsLocalFile(localFiles, remote.Name) {
+			if err := removeAll(conn, path.Join(remoteDir, remote.Name), remote.IsDir, summary); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func upload(conn *goftp.FTP, localPath, remotePath string) error {
+	if Debug {
+		log.Println(fmt.Sprintf("copy file: %s", remotePath))
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to open local file: %w", err)
+	}
+
+	defer f.Close()
+
+	if err := conn.Stor(remotePath, f); err != nil {
+		return fmt.Errorf("unable to upload: %s: %w", remotePath, err)
+	}
+
+	return nil
+}
+
+// countFiles returns 1 for a file or the amount of files below the given local directory.
+func countFiles(localPath string) int {
+	count := 0
+	_ = filepath.Walk(localPath, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+
+		return nil
+	})
+
+	return count
+}
+
+// removeAll deletes the remote file or the remote directory with all its children.
+func removeAll(conn *goftp.FTP, remotePath string, isDir bool, summary *deploy.SyncSummary) error {
+	if Debug {
+		log.Println(fmt.Sprintf("removing extra file: %s, isDir=%v", remotePath, isDir))
+	}
+
+	if !isDir {
+		if err := conn.Dele(remotePath); err != nil {
+			return fmt.Errorf("unable to remove: %s: %w", remotePath, err)
+		}
+
+		summary.Deleted++
+
+		return nil
+	}
+
+	children, err := list(conn, remotePath)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if err := removeAll(conn, path.Join(remotePath, child.Name), child.IsDir, summary); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Rmd(remotePath); err != nil {
+		return fmt.Errorf("unable to remove dir: %s: %w", remotePath, err)
+	}
+
+	summary.Deleted++
+
+	return nil
+}
+
+// isModified compares size and modification time. Listings are often only precise to the minute, but
+// the remote time is the upload time, which is usually later than the local modification.
+func isModified(local os.FileInfo, remote RemoteFile) bool {
+	if local.Size() != remote.Size || remote.ModTime.IsZero() {
+		return true
+	}
+
+	return local.ModTime().After(remote.ModTime)
+}
+
+func findRemoteFile(files []RemoteFile, name string) (RemoteFile, bool) {
+	for _, file := range files {
+		if file.Name == name {
+			return file, true
+		}
+	}
+
+	return RemoteFile{}, false
+}
+
+func containsLocalFile(files []os.FileInfo, name string) bool {
+	for _, file := range files {
+		if file.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseList parses the lines of a MLSD or a unix style LIST response. The entries . and .. and unparseable
+// lines are ignored.
+func parseList(lines []string) []RemoteFile {
+	var res []RemoteFile
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" || strings.HasPrefix(line, "total ") {
+			continue
+		}
+
+		var file RemoteFile
+		var ok bool
+		if strings.Contains(line, "type=") {
+			file, ok = parseMLSDLine(line)
+		} else {
+			file, ok = parseLISTLine(line, time.Now())
+		}
+
+		if !ok {
+			if Debug {
+				log.Println(fmt.Sprintf("ignoring unparseable list entry: %s", line))
+			}
+
+			continue
+		}
+
+		if file.Name == "." || file.Name == ".." {
+			continue
+		}
+
+		res = append(res, file)
+	}
+
+	return res
+}
+
+// parseMLSDLine parses a machine readable line like 'type=file;size=42;modify=20201231235959; index.html'.
+func parseMLSDLine(line string) (RemoteFile, bool) {
+	idx := strings.Index(line, " ")
+	if idx < 0 {
+		return RemoteFile{}, false
+	}
+
+	file := RemoteFile{Name: line[idx+1:]}
+	for _, fact := range strings.Split(line[:idx], ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "type":
+			switch strings.ToLower(kv[1]) {
+			case "cdir", "pdir":
+				return RemoteFile{}, false
+			case "dir":
+				file.IsDir = true
+			}
+		case "size":
+			file.Size, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "modify":
+			// the fraction of seconds is optional
+			if len(kv[1]) >= 14 {
+				file.ModTime, _ = time.Parse("20060102150405", kv[1][:14])
+			}
+		}
+	}
+
+	return file, true
+}
+
+// parseLISTLine parses a unix ls style line like '-rw-r--r-- 1 user group 42 Dec 31 23:59 index.html'.
+// Without a year, the most recent year before now is assumed.
+func parseLISTLine(line string, now time.Time) (RemoteFile, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 {
+		return RemoteFile{}, false
+	}
+
+	size, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return RemoteFile{}, false
+	}
+
+	file := RemoteFile{
+		Name:  strings.Join(fields[8:], " "),
+		Size:  size,
+		IsDir: strings.HasPrefix(fields[0], "d"),
+	}
+
+	if strings.HasPrefix(fields[0], "l") {
+		if idx := strings.Index(file.Name, " -> "); idx >= 0 {
+			file.Name = file.Name[:idx]
+		}
+	}
+
+	stamp := fields[5] + " " + fields[6] + " " + fields[7]
+	if strings.Contains(fields[7], ":") {
+		if t, err := time.Parse("Jan 2 15:04 2006", stamp+" "+strconv.Itoa(now.Year())); err == nil {
+			if t.After(now) {
+				t = t.AddDate(-1, 0, 0)
+			}
+
+			file.ModTime = t
+		}
+	} else if t, err := time.Parse("Jan 2 2006", stamp); err == nil {
+		file.ModTime = t
+	}
+
+	return file, true
+}
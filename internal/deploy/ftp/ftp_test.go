@@ -0,0 +1,109 @@
+package ftp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseList(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []RemoteFile
+	}{
+		{
+			name: "mlsd",
+			lines: []string{
+				"type=cdir;modify=20201231235959; .\r\n",
+				"type=pdir;modify=20201231235959; ..\r\n",
+				"type=file;size=42;modify=20201231235959.123;perm=r; index.html\r\n",
+				"type=dir;modify=20200101000000; assets\r\n",
+			},
+			want: []RemoteFile{
+				{Name: "index.html", Size: 42, ModTime: time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC)},
+				{Name: "assets", ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), IsDir: true},
+			},
+		},
+		{
+			name: "list",
+			lines: []string{
+				"total 8\r\n",
+				"drwxr-xr-x 2 user group 4096 Jan  1  2020 .\r\n",
+				"drwxr-xr-x 2 user group 4096 Jan  1  2020 ..\r\n",
+				"-rw-r--r-- 1 user group 42 Dec 31  2019 my index.html\r\n",
+				"drwxr-xr-x 2 user group 4096 Jan  1  2020 assets\r\n",
+				"lrwxrwxrwx 1 user group 10 Jan  1  2020 latest -> assets\r\n",
+			},
+			want: []RemoteFile{
+				{Name: "my index.html", Size: 42, ModTime: time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)},
+				{Name: "assets", Size: 4096, ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), IsDir: true},
+				{Name: "latest", Size: 10, ModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:  "garbage",
+			lines: []string{"hello world\r\n"},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseList(tt.lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseLISTLine_recentYear(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	file, ok := parseLISTLine("-rw-r--r-- 1 user group 42 Dec 31 23:59 index.html", now)
+	if !ok {
+		t.Fatal("expected a parsed line")
+	}
+
+	if want := time.Date(2020, 12, 31, 23, 59, 0, 0, time.UTC); !file.ModTime.Equal(want) {
+		t.Fatalf("expected %v but got %v", want, file.ModTime)
+	}
+}
+
+func TestSyncFTPInvalidExcludeGlob(t *testing.T) {
+	// the glob is validated before connecting
+	_, err := SyncFTP(FTPOptions{Host: "127.0.0.1", Port: 1}, ".", ".", "[")
+	if err == nil || !strings.Contains(err.Error(), "invalid exclude glob") {
+		t.Fatalf("expected invalid exclude glob error but got %v", err)
+	}
+}
+
+func Test_countFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp-count")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.map", "sub/b.map", "sub/deeper/c.map"} {
+		fname := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte("{}"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := countFiles(filepath.Join(dir, "a.map")); got != 1 {
+		t.Fatalf("expected 1 but got %d", got)
+	}
+
+	if got := countFiles(dir); got != 3 {
+		t.Fatalf("expected 3 but got %d", got)
+	}
+}
@@ -0,0 +1,268 @@
+// Package rsync contains a pure Go implementation of the rsync rolling checksum delta algorithm. Because there is
+// no rsync counterpart on the remote side, the block signatures of the remote file are calculated by reading it,
+// which is usually still cheaper than uploading, because most connections are asymmetric. Only blocks which
+// are not already at the same position in the remote file are written.
+package rsync
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/golangee/gotrino-make/internal/deploy"
+	"github.com/golangee/log"
+	"github.com/worldiety/go-tip/1.16/io/fs"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// DefaultBlockSize is the size of a block, whose checksum is compared.
+	DefaultBlockSize = 4 * 1024
+	// fullTransferLimit is the size in bytes below which a file is always uploaded completely.
+	fullTransferLimit = 1024 * 1024
+	// modAdler is the modulus of the weak rolling checksum.
+	modAdler = 1 << 16
+)
+
+// Debug is a global flag, which is only used by the command line program to track errors down.
+var Debug = false
+
+// WriterAt is implemented by remote files, which can be patched in place.
+type WriterAt interface {
+	WriteAt(p []byte, off int64) (int, error)
+}
+
+// Truncater is implemented by remote files, which can be cut off.
+type Truncater interface {
+	Truncate(size int64) error
+}
+
+// A Block describes the checksums of a single block of the base file.
+type Block struct {
+	Index  int      // Index of the block within the base file.
+	Weak   uint32   // Weak is the rolling checksum.
+	Strong [32]byte // Strong is the sha256 sum.
+}
+
+// A Signature contains the block checksums of a base file.
+type Signature struct {
+	BlockSize int
+	Blocks    []Block
+	weak      map[uint32][]int // weak maps the rolling checksum to block indices.
+}
+
+// An Op is a single instruction to reconstruct the new file from the base file.
+type Op struct {
+	Offset int64  // Offset within the new file.
+	Block  int    // Block is the index of the base block to copy or -1, if Data contains literal bytes.
+	Data   []byte // Data contains the literal bytes, if Block is -1.
+}
+
+// weakSum calculates the rsync (adler-32 like) checksum parts of the given block.
+func weakSum(block []byte) (a, b uint32) {
+	l := uint32(len(block))
+	for i, x := range block {
+		a += uint32(x)
+		b += (l - uint32(i)) * uint32(x)
+	}
+
+	return a % modAdler, b % modAdler
+}
+
+// NewSignature reads r entirely and calculates the checksums of each block.
+func NewSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	sig := &Signature{BlockSize: blockSize, weak: map[uint32][]int{}}
+	buf := make([]byte, blockSize)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			a, b := weakSum(buf[:n])
+			block := Block{Index: i, Weak: a | b<<16, Strong: sha256.Sum256(buf[:n])}
+			sig.Blocks = append(sig.Blocks, block)
+			sig.weak[block.Weak] = append(sig.weak[block.Weak], i)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read block %d: %w", i, err)
+		}
+	}
+
+	return sig, nil
+}
+
+// find returns the index of the block with the given content or -1.
+func (s *Signature) find(weak uint32, data []byte) int {
+	candidates, ok := s.weak[weak]
+	if !ok {
+		return -1
+	}
+
+	strong := sha256.Sum256(data)
+	for _, idx := range candidates {
+		if s.Blocks[idx].Strong == strong {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// Delta calculates the operations to transform the base file described by sig into data.
+func Delta(sig *Signature, data []byte) []Op {
+	var ops []Op
+	bs := sig.BlockSize
+	litStart := 0
+	i := 0
+
+	flush := func(end int) {
+		if end > litStart {
+			ops = append(ops, Op{Offset: int64(litStart), Block: -1, Data: data[litStart:end]})
+		}
+	}
+
+	var a, b uint32
+	fresh := true
+	for i+bs <= len(data) {
+		if fresh {
+			a, b = weakSum(data[i : i+bs])
+			fresh = false
+		}
+
+		if idx := sig.find(a|b<<16, data[i:i+bs]); idx >= 0 {
+			flush(i)
+			ops = append(ops, Op{Offset: int64(i), Block: idx})
+			i += bs
+			litStart = i
+			fresh = true
+			continue
+		}
+
+		// roll the window by one byte
+		if i+bs < len(data) {
+			out, in := uint32(data[i]), uint32(data[i+bs])
+			a = (a + modAdler - out + in) % modAdler
+			b = (b + modAdler*uint32(bs) - uint32(bs)*out + a) % modAdler
+		}
+
+		i++
+	}
+
+	flush(len(data))
+
+	return ops
+}
+
+// Patch writes the new file into w, by applying ops to the base file.
+func Patch(w io.Writer, base io.ReaderAt, blockSize int, ops []Op) error {
+	buf := make([]byte, blockSize)
+	for _, op := range ops {
+		if op.Block < 0 {
+			if _, err := w.Write(op.Data); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		n, err := base.ReadAt(buf, int64(op.Block)*int64(blockSize))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("unable to read base block %d: %w", op.Block, err)
+		}
+
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upload is a deploy.UploadFunc, which transfers only the changed blocks of large files. Small files, files
+// which do not exist in dst or files which cannot be patched in place are uploaded entirely.
+func Upload(dst, src fs.FS, name string) error {
+	srcFile, err := src.Open(name)
+	if err != nil {
+		return fmt.Errorf("unable to open src file: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(srcFile)
+	_ = srcFile.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read src file: %w", err)
+	}
+
+	if len(data) < fullTransferLimit {
+		return deploy.CopyFile(dst, src, name)
+	}
+
+	remote, err := dst.Open(name)
+	if err != nil {
+		return deploy.CopyFile(dst, src, name)
+	}
+
+	sig, err := NewSignature(remote, DefaultBlockSize)
+	_ = remote.Close()
+	if err != nil {
+		if Debug {
+			log.Println(fmt.Sprintf("rsync: no remote signature for %s, uploading entirely: %v", name, err))
+		}
+
+		return deploy.CopyFile(dst, src, name)
+	}
+
+	dstFile, err := dst.(deploy.OpenFile).OpenFile(name, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to open dst file: %w", err)
+	}
+
+	writer, canWrite := dstFile.(WriterAt)
+	truncater, canTruncate := dstFile.(Truncater)
+	if !canWrite || !canTruncate {
+		_ = dstFile.Close()
+		return deploy.CopyFile(dst, src, name)
+	}
+
+	written := 0
+	for _, op := range Delta(sig, data) {
+		// blocks which are already in place need no transfer
+		if op.Block >= 0 && int64(op.Block)*int64(sig.BlockSize) == op.Offset {
+			continue
+		}
+
+		chunk := op.Data
+		if op.Block >= 0 {
+			end := op.Offset + int64(sig.BlockSize)
+			if end > int64(len(data)) {
+				end = int64(len(data))
+			}
+
+			chunk = data[op.Offset:end]
+		}
+
+		if _, err := writer.WriteAt(chunk, op.Offset); err != nil {
+			_ = dstFile.Close()
+			return fmt.Errorf("unable to patch dst file: %w", err)
+		}
+
+		written += len(chunk)
+	}
+
+	if err := truncater.Truncate(int64(len(data))); err != nil {
+		_ = dstFile.Close()
+		return fmt.Errorf("unable to truncate dst file: %w", err)
+	}
+
+	if Debug {
+		log.Println(fmt.Sprintf("rsync: %s: transferred %d of %d bytes", name, written, len(data)))
+	}
+
+	return dstFile.Close()
+}
@@ -0,0 +1,65 @@
+package rsync
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func roundTrip(t *testing.T, base, data []byte) []Op {
+	t.Helper()
+
+	sig, err := NewSignature(bytes.NewReader(base), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := Delta(sig, data)
+	buf := &bytes.Buffer{}
+	if err := Patch(buf, bytes.NewReader(base), sig.BlockSize, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("patched result differs")
+	}
+
+	return ops
+}
+
+func literalBytes(ops []Op) int {
+	n := 0
+	for _, op := range ops {
+		n += len(op.Data)
+	}
+
+	return n
+}
+
+func TestDelta(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	base := make([]byte, 64*100+17)
+	rnd.Read(base)
+
+	// identical files only copy blocks, except the partial tail block
+	if n := literalBytes(roundTrip(t, base, base)); n != 17 {
+		t.Fatalf("expected 17 literal bytes but got %d", n)
+	}
+
+	// modify a single byte
+	modified := append([]byte{}, base...)
+	modified[1000] ^= 0xFF
+	if n := literalBytes(roundTrip(t, base, modified)); n > 64+17 {
+		t.Fatalf("too many literal bytes: %d", n)
+	}
+
+	// insert bytes, which shift all following blocks
+	inserted := append(append(append([]byte{}, base[:500]...), []byte("hello world")...), base[500:]...)
+	if n := literalBytes(roundTrip(t, base, inserted)); n > 2*64+11+17 {
+		t.Fatalf("too many literal bytes: %d", n)
+	}
+
+	// empty base and empty data
+	roundTrip(t, nil, base)
+	roundTrip(t, base, nil)
+}
@@ -0,0 +1,171 @@
+package sftp
+
+import (
+	"fmt"
+	"github.com/golangee/log"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultKeepAliveInterval is a reasonable period to keep most servers from closing idle connections.
+	DefaultKeepAliveInterval = 30 * time.Second
+	// maxReconnects is the amount of reconnect attempts after a failed keep-alive.
+	maxReconnects = 3
+	// DefaultConnectRetries is the amount of retries of a transient connection failure, see ConnectWithRetry.
+	DefaultConnectRetries = 3
+	// DefaultConnectDelay is the delay before the first retry, which doubles for each further retry.
+	DefaultConnectDelay = time.Second
+)
+
+// conn is the shared connection state of an FS and all of its sub filesystems.
+type conn struct {
+	opts      Options
+	lock      sync.Mutex
+	sshClient *ssh.Client
+	client    *sftp.Client
+	done      chan struct{}
+	closeOnce sync.Once
+	dirs      *dirCache // dirs is shared by all sub filesystems.
+}
+
+// sftpClient returns the current client.
+func (c *conn) sftpClient() *sftp.Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.client
+}
+
+// dial establishes a new ssh and sftp connection and replaces the current one.
+func (c *conn) dial() error {
+	config := &ssh.ClientConfig{
+		User:            c.opts.User,
+		Timeout:         30 * time.Second,
+		HostKeyCallback: c.opts.Callback,
+	}
+
+	if c.opts.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(c.opts.Password))
+	}
+
+	if c.opts.UseSSHAgent {
+		agentConn, err := dialAgent()
+		if err != nil {
+			if c.opts.Password == "" && c.opts.KeyFile == "" {
+				return fmt.Errorf("ssh agent unavailable and no password or key file given: %w", err)
+			}
+		} else {
+			defer agentConn.Close()
+
+			signers, err := agent.NewClient(agentConn).Signers()
+			if err != nil {
+				return fmt.Errorf("unable to get signers from ssh agent: %w", err)
+			}
+
+			config.Auth = append([]ssh.AuthMethod{ssh.PublicKeys(signers...)}, config.Auth...)
+		}
+	}
+
+	if c.opts.KeyFile != "" {
+		signer, err := loadKey(c.opts.KeyFile, c.opts.KeyPassphrase)
+		if err != nil {
+			return err
+		}
+
+		config.Auth = append([]ssh.AuthMethod{ssh.PublicKeys(signer)}, config.Auth...)
+	}
+
+	addr := fmt.Sprintf("%s:%d", c.opts.Host, c.opts.Port)
+	sshClient, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return fmt.Errorf("cannot connect to SSH service: %w", err)
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return fmt.Errorf("unable to create sftp client: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client != nil {
+		_ = c.client.Close()
+		_ = c.sshClient.Close()
+	}
+
+	c.sshClient = sshClient
+	c.client = client
+
+	return nil
+}
+
+// keepAlive periodically sends keep-alive requests until closed. If a request fails, it tries to reconnect.
+func (c *conn) keepAlive() {
+	ticker := time.NewTicker(c.opts.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.lock.Lock()
+			sshClient := c.sshClient
+			c.lock.Unlock()
+
+			if _, _, err := sshClient.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+				continue
+			}
+
+			if err := c.reconnect(); err != nil {
+				log.Println("sftp: keep-alive failed, giving up", err)
+				return
+			}
+		}
+	}
+}
+
+// reconnect tries to dial up to maxReconnects times.
+func (c *conn) reconnect() error {
+	var err error
+	for i := 0; i < maxReconnects; i++ {
+		select {
+		case <-c.done:
+			return nil
+		default:
+		}
+
+		if err = c.dial(); err == nil {
+			log.Println("sftp: reconnected")
+			return nil
+		}
+
+		log.Println(fmt.Sprintf("sftp: reconnect %d/%d failed", i+1, maxReconnects), err)
+		time.Sleep(time.Second)
+	}
+
+	return fmt.Errorf("unable to reconnect after %d attempts: %w", maxReconnects, err)
+}
+
+// close stops the keep-alive and closes the connection.
+func (c *conn) close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.client.Close(); err != nil {
+		_ = c.sshClient.Close()
+		return err
+	}
+
+	return c.sshClient.Close()
+}
@@ -0,0 +1,78 @@
+package sftp
+
+import (
+	"github.com/worldiety/go-tip/1.16/io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dirCache keeps the entries of remote directories for a ttl, because each ReadDir is a round trip.
+// A ttl of 0 disables the cache.
+type dirCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]dirCacheEntry
+}
+
+type dirCacheEntry struct {
+	expires time.Time
+	entries []fs.DirEntry
+}
+
+// get returns the cached entries of the directory, if they have not yet expired.
+func (c *dirCache) get(name string, now time.Time) ([]fs.DirEntry, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[name]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+
+	return entry.entries, true
+}
+
+// put remembers the entries of the directory until now+ttl.
+func (c *dirCache) put(name string, entries []fs.DirEntry, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]dirCacheEntry{}
+	}
+
+	c.entries[name] = dirCacheEntry{expires: now.Add(c.ttl), entries: entries}
+}
+
+// clear forgets all entries, which must be called after each modification of the remote side.
+func (c *dirCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = nil
+}
+
+// sortedEntries converts the infos into entries, sorted by name. Servers usually return them in their
+// storage order, but a sync compares the entries of both sides in order.
+func sortedEntries(files []os.FileInfo) []fs.DirEntry {
+	res := make([]fs.DirEntry, 0, len(files))
+	for _, info := range files {
+		res = append(res, infoDelegate{info})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+
+	return res
+}
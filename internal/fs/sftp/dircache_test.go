@@ -0,0 +1,73 @@
+package sftp
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSortedEntries(t *testing.T) {
+	files := []os.FileInfo{testInfo("index.html"), testInfo("app.wasm"), testInfo("css")}
+
+	entries := sortedEntries(files)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	if len(names) != 3 || names[0] != "app.wasm" || names[1] != "css" || names[2] != "index.html" {
+		t.Fatalf("expected entries sorted by name but got %v", names)
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	now := time.Now()
+	entries := sortedEntries([]os.FileInfo{testInfo("a")})
+
+	disabled := &dirCache{}
+	disabled.put("/www", entries, now)
+	if _, ok := disabled.get("/www", now); ok {
+		t.Fatal("expected a ttl of 0 to disable the cache")
+	}
+
+	cache := &dirCache{ttl: time.Minute}
+	cache.put("/www", entries, now)
+	if cached, ok := cache.get("/www", now.Add(time.Second)); !ok || len(cached) != 1 {
+		t.Fatalf("expected a cache hit but got %v", cached)
+	}
+
+	if _, ok := cache.get("/www", now.Add(time.Minute)); ok {
+		t.Fatal("expected the entry to be expired")
+	}
+
+	cache.clear()
+	if _, ok := cache.get("/www", now); ok {
+		t.Fatal("expected a cleared cache")
+	}
+}
+
+type testInfo string
+
+func (i testInfo) Name() string {
+	return string(i)
+}
+
+func (i testInfo) Size() int64 {
+	return 0
+}
+
+func (i testInfo) Mode() os.FileMode {
+	return 0
+}
+
+func (i testInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i testInfo) IsDir() bool {
+	return false
+}
+
+func (i testInfo) Sys() interface{} {
+	return nil
+}
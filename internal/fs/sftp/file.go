@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/pkg/sftp"
 	"github.com/worldiety/go-tip/1.16/io/fs"
+	"io"
 	"os"
+	"time"
 )
 
 var _ fs.ReadDirFile = (*file)(nil)
@@ -20,21 +22,23 @@ type file struct {
 // ReadDir reads the directory named by dirname and returns a list of
 // directory entries.
 func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
-	files, err := f.parent.client.ReadDir(f.name)
+	if entries, ok := f.parent.conn.dirs.get(f.name, time.Now()); ok {
+		return entries, nil
+	}
+
+	files, err := f.parent.client().ReadDir(f.name)
 	if err != nil {
 		return nil, err
 	}
 
-	res := make([]fs.DirEntry, 0, len(files))
-	for _, info := range files {
-		res = append(res, infoDelegate{info})
-	}
+	res := sortedEntries(files)
+	f.parent.conn.dirs.put(f.name, res, time.Now())
 
 	return res, nil
 }
 
 func (f *file) Stat() (fs.FileInfo, error) {
-	info, err := f.parent.client.Stat(f.name)
+	info, err := f.parent.client().Stat(f.name)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +49,7 @@ func (f *file) Stat() (fs.FileInfo, error) {
 // Read follows io.Reader semantics.
 func (f *file) Read(bytes []byte) (int, error) {
 	if f.openFile == nil {
-		file, err := f.parent.client.Open(f.name)
+		file, err := f.parent.client().Open(f.name)
 		if err != nil {
 			return 0, fmt.Errorf("unable to open file '%s': %w", f.name, err)
 		}
@@ -58,16 +62,49 @@ func (f *file) Read(bytes []byte) (int, error) {
 
 // Write follows io.Writer semantics.
 func (f *file) Write(bytes []byte) (int, error) {
+	if err := f.openWrite(); err != nil {
+		return 0, err
+	}
+
+	return f.openFile.Write(bytes)
+}
+
+// WriteAt follows io.WriterAt semantics.
+func (f *file) WriteAt(bytes []byte, off int64) (int, error) {
+	if err := f.openWrite(); err != nil {
+		return 0, err
+	}
+
+	if _, err := f.openFile.Seek(off, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("unable to seek file '%s': %w", f.name, err)
+	}
+
+	return f.openFile.Write(bytes)
+}
+
+// Truncate changes the size of the file.
+func (f *file) Truncate(size int64) error {
+	if err := f.openWrite(); err != nil {
+		return err
+	}
+
+	return f.openFile.Truncate(size)
+}
+
+// openWrite lazily opens the file using the flags from OpenFile.
+func (f *file) openWrite() error {
 	if f.openFile == nil {
-		file, err := f.parent.client.OpenFile(f.name, f.flag)
+		f.parent.conn.dirs.clear()
+
+		file, err := f.parent.client().OpenFile(f.name, f.flag)
 		if err != nil {
-			return 0, fmt.Errorf("unable to openFile file '%s': %w", f.name, err)
+			return fmt.Errorf("unable to openFile file '%s': %w", f.name, err)
 		}
 
 		f.openFile = file
 	}
 
-	return f.openFile.Write(bytes)
+	return nil
 }
 
 // Close closes the File, rendering it unusable for I/O.
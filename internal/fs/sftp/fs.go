@@ -1,11 +1,18 @@
 package sftp
 
 import (
+	"errors"
 	"fmt"
+	"github.com/golangee/log"
 	"github.com/pkg/sftp"
 	"github.com/worldiety/go-tip/1.16/io/fs"
 	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -16,21 +23,39 @@ type Options struct {
 	User     string
 	Password string
 	Callback ssh.HostKeyCallback // Callback default is ssh.InsecureIgnoreHostKey which must be considered insecure.
+	// UseSSHAgent tries to authenticate with the keys of the agent at SSH_AUTH_SOCK before falling back to Password.
+	UseSSHAgent bool
+	// KeyFile is an optional private key file, which is used for public key authentication before the Password.
+	KeyFile string
+	// KeyPassphrase decrypts the KeyFile, if it is encrypted.
+	KeyPassphrase string
+	// KeepAliveInterval is the period between keep-alive requests to prevent idle timeouts. 0 disables it.
+	// See also DefaultKeepAliveInterval.
+	KeepAliveInterval time.Duration
+	// ReadDirCacheTTL keeps the entries of each directory for repeated ReadDir calls. Any modification through
+	// the FS clears the cache. 0 disables the cache.
+	ReadDirCacheTTL time.Duration
 }
 
 // assert interface
 var _ fs.ReadDirFS = (*FS)(nil)
 var _ fs.SubFS = (*FS)(nil)
+var _ fs.StatFS = (*FS)(nil)
 
 type FS struct {
 	prefix string
-	client *sftp.Client
+	conn   *conn
+}
+
+// client returns the current sftp client, which may change after a reconnect.
+func (f *FS) client() *sftp.Client {
+	return f.conn.sftpClient()
 }
 
 func (f *FS) Sub(dir string) (fs.FS, error) {
 	return &FS{
 		prefix: f.prefix + "/" + dir,
-		client: f.client,
+		conn:   f.conn,
 	}, nil
 }
 
@@ -50,7 +75,8 @@ func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 // If path contains a regular file, an error is returned
 func (f *FS) MkdirAll(name string) error {
 	name = f.prefix + "/" + name
-	return f.client.MkdirAll(name)
+	f.conn.dirs.clear()
+	return f.client().MkdirAll(name)
 }
 
 // Mkdir creates the specified directory. An error will be returned if a file or
@@ -58,12 +84,68 @@ func (f *FS) MkdirAll(name string) error {
 // parent folder does not exist (the method cannot create complete paths).
 func (f *FS) Mkdir(name string) error {
 	name = f.prefix + "/" + name
-	return f.client.Mkdir(name)
+	f.conn.dirs.clear()
+	return f.client().Mkdir(name)
+}
+
+// Chmod changes the permissions of the named file.
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	name = f.prefix + "/" + name
+	f.conn.dirs.clear()
+	return f.client().Chmod(name, mode)
+}
+
+// Stat returns the size, mode and modification time of the named file without opening it. Symbolic links
+// are followed.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.client().Stat(f.prefix + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+
+	return infoDelegate{info}, nil
+}
+
+// Lstat is like Stat, but describes a symbolic link itself instead of its target.
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	info, err := f.client().Lstat(f.prefix + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+
+	return infoDelegate{info}, nil
+}
+
+// Rename moves oldPath to newPath and replaces an existing newPath atomically, if the server supports the
+// posix-rename@openssh.com extension. Otherwise, the plain sftp rename is used, which fails for an existing
+// newPath.
+func (f *FS) Rename(oldPath, newPath string) error {
+	oldPath = f.prefix + "/" + oldPath
+	newPath = f.prefix + "/" + newPath
+	f.conn.dirs.clear()
+
+	err := f.client().PosixRename(oldPath, newPath)
+	if isUnsupported(err) {
+		err = f.client().Rename(oldPath, newPath)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to rename '%s' to '%s': %w", oldPath, newPath, err)
+	}
+
+	return nil
+}
+
+// isUnsupported returns true, if the server does not support the requested operation or extension.
+func isUnsupported(err error) bool {
+	var statusErr *sftp.StatusError
+	return errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported
 }
 
 func (f *FS) RemoveAll(name string) error {
 	name = f.prefix + "/" + name
-	stat, err := f.client.Stat(name)
+	f.conn.dirs.clear()
+	stat, err := f.client().Stat(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -73,7 +155,7 @@ func (f *FS) RemoveAll(name string) error {
 	}
 
 	if stat.IsDir() {
-		files, err := f.client.ReadDir(name)
+		files, err := f.client().ReadDir(name)
 		if err != nil {
 			return fmt.Errorf("unable to readdir: %w", err)
 		}
@@ -85,11 +167,11 @@ func (f *FS) RemoveAll(name string) error {
 			}
 		}
 
-		if err := f.client.RemoveDirectory(name); err != nil {
+		if err := f.client().RemoveDirectory(name); err != nil {
 			return fmt.Errorf("unable to remove cleared directory: %w", err)
 		}
 	} else {
-		return f.client.Remove(name)
+		return f.client().Remove(name)
 	}
 
 	return nil
@@ -122,23 +204,110 @@ func Connect(opts Options) (*FS, error) {
 		opts.Callback = ssh.InsecureIgnoreHostKey()
 	}
 
-	config := &ssh.ClientConfig{
-		User:            opts.User,
-		Auth:            []ssh.AuthMethod{ssh.Password(opts.Password)},
-		Timeout:         30 * time.Second,
-		HostKeyCallback: opts.Callback,
+	if opts.Password == "" && opts.KeyFile == "" && !opts.UseSSHAgent {
+		return nil, fmt.Errorf("either a password, a key file or the ssh agent is required")
+	}
+
+	c := &conn{
+		opts: opts,
+		done: make(chan struct{}),
+		dirs: &dirCache{ttl: opts.ReadDirCacheTTL},
+	}
+
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+
+	if opts.KeepAliveInterval > 0 {
+		go c.keepAlive()
 	}
 
-	addr := fmt.Sprintf("%s:%d", opts.Host, opts.Port)
-	conn, err := ssh.Dial("tcp", addr, config)
+	return &FS{conn: c}, nil
+}
+
+// ConnectWithRetry invokes Connect up to maxRetries additional times, if it fails for a transient reason like a
+// refused, reset or timed out connection. The delay starts at baseDelay and doubles after each attempt. Other
+// errors, e.g. a rejected authentication, are returned immediately.
+func ConnectWithRetry(opts Options, maxRetries int, baseDelay time.Duration) (*FS, error) {
+	delay := baseDelay
+	for attempt := 0; ; attempt++ {
+		res, err := Connect(opts)
+		if err == nil {
+			return res, nil
+		}
+
+		if attempt >= maxRetries || !isRetryable(err) {
+			return nil, err
+		}
+
+		log.Println(fmt.Sprintf("sftp: connect %d/%d failed, retrying in %v", attempt+1, maxRetries+1, delay), err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isRetryable returns true, if the connection may succeed later. A server may also close the connection during
+// the handshake, e.g. if the amount of unauthenticated connections is limited by the sshd MaxStartups.
+func isRetryable(err error) bool {
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	// ssh.Dial formats the handshake error with %v, so that only its text is available
+	msg := err.Error()
+	if strings.Contains(msg, "ssh: handshake failed:") {
+		return strings.HasSuffix(msg, io.EOF.Error()) || strings.HasSuffix(msg, syscall.ECONNRESET.Error())
+	}
+
+	return false
+}
+
+// Close stops the keep-alive and closes the connection. All sub filesystems become unusable.
+func (f *FS) Close() error {
+	return f.conn.close()
+}
+
+// loadKey parses the private key file and decrypts it with the passphrase, if given.
+func loadKey(fname, passphrase string) (ssh.Signer, error) {
+	buf, err := ioutil.ReadFile(fname)
 	if err != nil {
-		return nil, fmt.Errorf("cannot connect to SSH service: %w", err)
+		return nil, fmt.Errorf("unable to read key file: %w", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(buf, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(buf)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse key file '%s': %w", fname, err)
+	}
+
+	return signer, nil
+}
+
+// dialAgent connects to the ssh agent announced by the SSH_AUTH_SOCK environment variable.
+func dialAgent() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
 	}
 
-	client, err := sftp.NewClient(conn)
+	conn, err := net.Dial("unix", sock)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create sftp client: %w", err)
+		return nil, fmt.Errorf("unable to connect to ssh agent at '%s': %w", sock, err)
 	}
 
-	return &FS{client: client}, nil
+	return conn, nil
 }
@@ -0,0 +1,72 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsUnsupported(t *testing.T) {
+	unsupported := &sftp.StatusError{Code: uint32(sftp.ErrSSHFxOpUnsupported)}
+	if !isUnsupported(unsupported) || !isUnsupported(fmt.Errorf("rename: %w", unsupported)) {
+		t.Fatal("expected SSH_FX_OP_UNSUPPORTED to be unsupported")
+	}
+
+	for _, err := range []error{nil, os.ErrNotExist, &sftp.StatusError{Code: uint32(sftp.ErrSSHFxFailure)}} {
+		if isUnsupported(err) {
+			t.Fatalf("expected %v to be supported", err)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: fmt.Errorf("cannot connect to SSH service: %w", refused), want: true},
+		{err: fmt.Errorf("handshake: %w", io.EOF), want: true},
+		// shaped like the errors of ssh.Dial, which does not wrap the cause of a failed handshake
+		{err: fmt.Errorf("cannot connect to SSH service: %w", fmt.Errorf("ssh: handshake failed: %v", io.EOF)), want: true},
+		{err: fmt.Errorf("cannot connect to SSH service: %w", fmt.Errorf("ssh: handshake failed: %v", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)})), want: true},
+		{err: fmt.Errorf("cannot connect to SSH service: %w", fmt.Errorf("ssh: handshake failed: %v", errors.New("ssh: host key mismatch"))), want: false},
+		{err: fmt.Errorf("cannot connect to SSH service: %w", fmt.Errorf("ssh: handshake failed: %v", errors.New("ssh: unable to authenticate, attempted methods [none], no supported methods remain"))), want: false},
+		{err: fmt.Errorf("cannot connect to SSH service: %w", errors.New("ssh: unable to authenticate, attempted methods [none password], no supported methods remain")), want: false},
+		{err: errors.New("either a password, a key file or the ssh agent is required"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Fatalf("%v: expected %v but got %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	// a closed listener provides a port, which refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	start := time.Now()
+	_, err = ConnectWithRetry(Options{Host: "127.0.0.1", Port: port, Password: "secret"}, 2, 10*time.Millisecond)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected connection refused but got %v", err)
+	}
+
+	// 10ms + 20ms backoff
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatalf("expected 2 retries with backoff but took %v", time.Since(start))
+	}
+}
@@ -19,14 +19,25 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/golangee/log"
 	"github.com/golangee/log/ecs"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// maxUserWatchesFile is the linux specific inotify limit per user.
+const maxUserWatchesFile = "/proc/sys/fs/inotify/max_user_watches"
+
+// defaultDebounce is used, if no debounce duration has been configured.
+const defaultDebounce = 1 * time.Second
+
+// saturationWarnPct is the percentage of used inotify watches, which causes a warning.
+const saturationWarnPct = 80
+
 // Watcher is a recursive fsnotify implementation.
 type Watcher struct {
 	fsw                *fsnotify.Watcher
@@ -34,7 +45,8 @@ type Watcher struct {
 	watchedDirLock     sync.Mutex
 	lastMod            int64
 	lastModRebuild     int64
-	dir                string
+	debounce           time.Duration
+	roots              []string
 	logger             log.Logger
 	onNotify           func()
 }
@@ -42,18 +54,23 @@ type Watcher struct {
 // NewWatcher creates a new recursive fsnotify watch on all directories.
 // If something is added or renamed, that watch tree is re-created.
 // The given callback is not called for each change, but aggregated
-// within a time window of second. It gets only called, as soon as
-// all changes within a second have been applied, so an ever-changing
+// within a time window of the debounce duration (a second, if zero). It gets only called,
+// as soon as all changes within that window have been applied, so an ever-changing
 // directory will cause the callback to be never called.
-func NewWatcher(root string, onNotifyCallback func()) (*Watcher, error) {
+func NewWatcher(root string, debounce time.Duration, onNotifyCallback func()) (*Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("no fsnotify support")
 	}
 
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+
 	w := &Watcher{
 		fsw:      watcher,
-		dir:      root,
+		roots:    []string{root},
+		debounce: debounce,
 		onNotify: onNotifyCallback,
 		logger:   log.NewLogger(ecs.Log("fsnotify"), ecs.URLPath(root)),
 	}
@@ -90,13 +107,78 @@ func NewWatcher(root string, onNotifyCallback func()) (*Watcher, error) {
 		}
 	}()
 
-	if err := w.updateRecursiveWatch(root); err != nil {
+	if err := w.updateRecursiveWatch(); err != nil {
 		return nil, err
 	}
 
+	if max, err := MaxUserWatches(); err == nil && max > 0 {
+		if pct := Saturation(w.WatchedCount(), max); pct > saturationWarnPct {
+			w.logger.Println(ecs.Msg(fmt.Sprintf("warning: %d of %d inotify watches in use (%.1f%%), changes may be missed", w.WatchedCount(), max, pct)))
+		}
+	}
+
 	return w, nil
 }
 
+// WatchedCount returns the amount of currently watched directories.
+func (w *Watcher) WatchedCount() int {
+	w.watchedDirLock.Lock()
+	defer w.watchedDirLock.Unlock()
+
+	return len(w.watchedDirectories)
+}
+
+// MaxUserWatches returns the inotify watch limit per user. This is only available on linux, other
+// platforms return 0.
+func MaxUserWatches() (int, error) {
+	buf, err := ioutil.ReadFile(maxUserWatchesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("unable to read inotify limit: %w", err)
+	}
+
+	max, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse inotify limit: %w", err)
+	}
+
+	return max, nil
+}
+
+// Saturation returns the percentage of used watches in relation to max. If max is unknown, 0 is returned.
+func Saturation(watched, max int) float64 {
+	if max <= 0 {
+		return 0
+	}
+
+	return float64(watched) / float64(max) * 100
+}
+
+// AddRoot adds another directory tree to watch recursively, without clearing the existing watches.
+// Adding an already known root is a no-op.
+func (w *Watcher) AddRoot(dir string) error {
+	w.watchedDirLock.Lock()
+	defer w.watchedDirLock.Unlock()
+
+	for _, root := range w.roots {
+		if root == dir {
+			return nil
+		}
+	}
+
+	// only remember the root once it is watched, so that a failed attempt is retried by the next call
+	if err := w.watchTree(dir); err != nil {
+		return err
+	}
+
+	w.roots = append(w.roots, dir)
+
+	return nil
+}
+
 // notifyDelayedChange post-pones events, so that massive changes
 // won't overload the system. It is fine to miss events, as long
 // as we are still "dirty".
@@ -112,7 +194,7 @@ func (w *Watcher) notifyDelayedChange(fname string, rebuild bool) {
 func (w *Watcher) checkLater() {
 	myGen := atomic.LoadInt64(&w.lastMod)
 
-	time.AfterFunc(1*time.Second, func() {
+	time.AfterFunc(w.debounce, func() {
 		actualGen := atomic.LoadInt64(&w.lastMod)
 
 		if myGen != actualGen {
@@ -121,7 +203,7 @@ func (w *Watcher) checkLater() {
 
 		rebuild := atomic.LoadInt64(&w.lastModRebuild) == 1
 		if rebuild {
-			if err := w.updateRecursiveWatch(w.dir); err != nil {
+			if err := w.updateRecursiveWatch(); err != nil {
 				w.logger.Println(ecs.Msg("unable to update recursive watch"), ecs.ErrMsg(err))
 			}
 		}
@@ -133,8 +215,8 @@ func (w *Watcher) checkLater() {
 }
 
 // updateRecursiveWatch cleans up all ever registered file watches
-// and attaches new watches to all non-hidden folders.
-func (w *Watcher) updateRecursiveWatch(root string) error {
+// and attaches new watches to all non-hidden folders of all roots.
+func (w *Watcher) updateRecursiveWatch() error {
 	w.watchedDirLock.Lock()
 	defer w.watchedDirLock.Unlock()
 
@@ -146,6 +228,18 @@ func (w *Watcher) updateRecursiveWatch(root string) error {
 
 	w.watchedDirectories = w.watchedDirectories[:0]
 
+	for _, root := range w.roots {
+		if err := w.watchTree(root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watchTree attaches watches to all non-hidden folders of root. The caller must hold the watchedDirLock.
+func (w *Watcher) watchTree(root string) error {
+	var dirs []string
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -159,7 +253,7 @@ func (w *Watcher) updateRecursiveWatch(root string) error {
 			return filepath.SkipDir
 		}
 
-		w.watchedDirectories = append(w.watchedDirectories, path)
+		dirs = append(dirs, path)
 		return nil
 	})
 
@@ -167,10 +261,12 @@ func (w *Watcher) updateRecursiveWatch(root string) error {
 		return fmt.Errorf("unable to search %s: %w", root, err)
 	}
 
-	for _, directory := range w.watchedDirectories {
+	for _, directory := range dirs {
 		if err := w.fsw.Add(directory); err != nil {
 			return fmt.Errorf("unable to attach watch %s: %w", directory, err)
 		}
+
+		w.watchedDirectories = append(w.watchedDirectories, directory)
 	}
 
 	return nil
@@ -20,3 +20,32 @@ func Head(dir string) (string, error) {
 
 	return strings.TrimSpace(string(res)), nil
 }
+
+// ShortHash returns the abbreviated commit hash of HEAD.
+func ShortHash(dir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("unable to call git: %w", err)
+	}
+
+	return strings.TrimSpace(string(res)), nil
+}
+
+// Describe returns a human readable version of HEAD like v1.2.3-5-gabcdef1-dirty. If git describe fails,
+// e.g. because no tags exist, the abbreviated commit hash is returned instead.
+func Describe(dir string) (string, error) {
+	cmd := exec.Command("git", "describe", "--tags", "--always", "--dirty")
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		return ShortHash(dir)
+	}
+
+	return strings.TrimSpace(string(res)), nil
+}
@@ -15,11 +15,18 @@
 package gotool
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/golangee/gotrino-make/internal/io"
 	"github.com/golangee/log"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -37,26 +44,402 @@ type Module struct {
 	}
 }
 
+// Package is a subset of the package information of go list.
+type Package struct {
+	ImportPath  string   // ImportPath is the full import path of the package.
+	Dir         string   // Dir is the local folder, which contains the package source.
+	GoFiles     []string // GoFiles are the names of the non-test .go files, relative to Dir.
+	Imports     []string // Imports are the import paths used by the GoFiles.
+	TestGoFiles []string // TestGoFiles are the names of the _test.go files of the package itself.
+}
+
+// hostTarget removes a GOOS and GOARCH, which may have been inherited from the shell, so that the go tool
+// uses the host platform.
+var hostTarget = map[string]string{"GOOS": "", "GOARCH": ""}
+
+// sanitizeEnv returns a copy of env without the entries for the keys of overrides. Afterwards, each override
+// with a non-empty value is appended as KEY=VALUE. An empty value just removes the key.
+func sanitizeEnv(env []string, overrides map[string]string) []string {
+	res := make([]string, 0, len(env)+len(overrides))
+	for _, kv := range env {
+		key := kv
+		if idx := strings.Index(kv, "="); idx >= 0 {
+			key = kv[:idx]
+		}
+
+		if _, ok := overrides[key]; !ok {
+			res = append(res, kv)
+		}
+	}
+
+	var keys []string
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if overrides[key] != "" {
+			res = append(res, key+"="+overrides[key])
+		}
+	}
+
+	return res
+}
+
+// envOverrides converts KEY=VALUE entries into overrides for sanitizeEnv. Entries without = are ignored.
+func envOverrides(env []string) map[string]string {
+	res := map[string]string{}
+	for _, kv := range env {
+		if idx := strings.Index(kv, "="); idx > 0 {
+			res[kv[:idx]] = kv[idx+1:]
+		}
+	}
+
+	return res
+}
+
+// hostOverrides returns the overrides of hostTarget, merged with the given KEY=VALUE entries, which win.
+func hostOverrides(extraEnv []string) map[string]string {
+	res := map[string]string{}
+	for key, value := range hostTarget {
+		res[key] = value
+	}
+
+	for key, value := range envOverrides(extraEnv) {
+		res[key] = value
+	}
+
+	return res
+}
+
 // ModTidy invokes go mod tidy in the given directory. It will clean up deps and download their source.
+// The extraEnv entries (KEY=VALUE) replace those of the inherited environment, e.g. to set GONOSUMDB for
+// private modules. An inherited GOOS and GOARCH is removed, unless set by extraEnv.
 // See also https://golang.org/ref/mod#go-mod-tidy.
-func ModTidy(dir string) (string, error) {
+func ModTidy(dir string, extraEnv ...string) (string, error) {
 	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Env = sanitizeEnv(os.Environ(), hostOverrides(extraEnv))
+	cmd.Dir = dir
+
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("cannot go mod tidy: %s: %w", string(res), err)
+	}
+
+	return strings.TrimSpace(string(res)), nil
+}
+
+// ModTidyCheck runs go mod tidy in a temporary copy of the given module directory and returns an error listing
+// the differences, if the go.mod or go.sum of the original module is not tidy. Vendored modules are not checked.
+func ModTidyCheck(dir string, extraEnv ...string) error {
+	if stat, err := os.Stat(filepath.Join(dir, "vendor")); err == nil && stat.IsDir() {
+		if Debug {
+			log.Println("modTidyCheck: skipping vendored module " + dir)
+		}
+
+		return nil
+	}
+
+	// a hidden sibling keeps relative replace directives intact
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dir), ".gotrino-tidy-check")
+	if err != nil {
+		return fmt.Errorf("unable to create temp dir: %w", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	if err := io.CopyDir(tmpDir, dir); err != nil {
+		return fmt.Errorf("unable to copy module: %w", err)
+	}
+
+	if _, err := ModTidy(tmpDir, extraEnv...); err != nil {
+		return err
+	}
+
+	var diffs []string
+	for _, name := range []string{"go.mod", "go.sum"} {
+		original, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read %s: %w", name, err)
+		}
+
+		tidy, err := ioutil.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read tidy %s: %w", name, err)
+		}
+
+		for _, line := range DiffLines(string(original), string(tidy)) {
+			diffs = append(diffs, name+": "+line)
+		}
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("go.mod or go.sum is not tidy:\n%s", strings.Join(diffs, "\n"))
+	}
+
+	return nil
+}
+
+// DiffLines returns the lines which are only in a (prefixed with -) or only in b (prefixed with +).
+func DiffLines(a, b string) []string {
+	count := map[string]int{}
+	for _, line := range strings.Split(a, "\n") {
+		count[line]--
+	}
+
+	for _, line := range strings.Split(b, "\n") {
+		count[line]++
+	}
+
+	var res []string
+	for _, line := range strings.Split(a, "\n") {
+		if count[line] < 0 {
+			res = append(res, "- "+line)
+			count[line]++
+		}
+	}
+
+	for _, line := range strings.Split(b, "\n") {
+		if count[line] > 0 {
+			res = append(res, "+ "+line)
+			count[line]--
+		}
+	}
+
+	return res
+}
+
+// modEditFlags are the supported flags of go mod edit, mapped to true, if they require a value.
+var modEditFlags = map[string]bool{
+	"fmt":         false,
+	"module":      true,
+	"go":          true,
+	"require":     true,
+	"droprequire": true,
+	"exclude":     true,
+	"dropexclude": true,
+	"replace":     true,
+	"dropreplace": true,
+	"retract":     true,
+	"dropretract": true,
+}
+
+// ModEdit invokes go mod edit with the given edits in the given directory, e.g. -require=example.com/a@v1.2.3
+// or -replace=example.com/a=../a. Malformed edits are rejected before go is invoked. Remember to invoke ModTidy
+// afterwards. See also https://golang.org/ref/mod#go-mod-edit.
+func ModEdit(dir string, edits ...string) (string, error) {
+	if len(edits) == 0 {
+		return "", fmt.Errorf("no go mod edit given")
+	}
+
+	for _, edit := range edits {
+		if err := checkModEdit(edit); err != nil {
+			return "", err
+		}
+	}
+
+	cmd := exec.Command("go", append([]string{"mod", "edit"}, edits...)...)
 	cmd.Env = os.Environ()
 	cmd.Dir = dir
 
 	res, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("cannot go generate: %s: %w", string(res), err)
+		return "", fmt.Errorf("cannot go mod edit %s: %s: %w", strings.Join(edits, " "), string(res), err)
 	}
 
 	return strings.TrimSpace(string(res)), nil
 }
 
-// Generate invokes go generate ./... in the given directory.
-func Generate(dir string) (string, error) {
+// checkModEdit returns an error, if the edit is not a supported go mod edit flag or if its value is missing.
+func checkModEdit(edit string) error {
+	if !strings.HasPrefix(edit, "-") {
+		return fmt.Errorf("invalid go mod edit '%s': must start with -", edit)
+	}
+
+	name, value := strings.TrimLeft(edit, "-"), ""
+	hasValue := false
+	if i := strings.Index(name, "="); i >= 0 {
+		name, value, hasValue = name[:i], name[i+1:], true
+	}
+
+	requiresValue, ok := modEditFlags[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("invalid go mod edit '%s': unsupported flag -%s", edit, name)
+	case requiresValue && (!hasValue || value == ""):
+		return fmt.Errorf("invalid go mod edit '%s': expected -%s=<value>", edit, name)
+	case !requiresValue && hasValue:
+		return fmt.Errorf("invalid go mod edit '%s': -%s has no value", edit, name)
+	case name == "replace" && !strings.Contains(value, "="):
+		return fmt.Errorf("invalid go mod edit '%s': expected -replace=<old>=<new>", edit)
+	}
+
+	return nil
+}
+
+// ModReplace adds or updates a replace directive in the go.mod of the given directory, so that the module
+// old (optionally with an @version suffix) is replaced by newLocal. Remember to invoke ModTidy afterwards.
+func ModReplace(moduleDir, old, newLocal string) error {
+	_, err := ModEdit(moduleDir, "-replace="+old+"="+newLocal)
+
+	return err
+}
+
+// ModPin pins the dependency path to the exact version using go get and tidies the module afterwards. The
+// version may be a semantic version like v1.2.3, a pseudo-version or a commit hash. The extraEnv entries
+// (KEY=VALUE) replace those of the inherited environment, just like for ModTidy.
+func ModPin(moduleDir, path, version string, extraEnv ...string) error {
+	// a leading dash would be parsed as a flag of go get
+	if path == "" || strings.HasPrefix(path, "-") || strings.ContainsAny(path, "@ \t") {
+		return fmt.Errorf("invalid module path: '%s'", path)
+	}
+
+	if version == "" || strings.HasPrefix(version, "-") || strings.ContainsAny(version, "@ \t") {
+		return fmt.Errorf("invalid module version: '%s'", version)
+	}
+
+	cmd := exec.Command("go", "get", path+"@"+version)
+	cmd.Env = sanitizeEnv(os.Environ(), hostOverrides(extraEnv))
+	cmd.Dir = moduleDir
+
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot go get %s@%s: %s: %w", path, version, string(res), err)
+	}
+
+	if _, err := ModTidy(moduleDir, extraEnv...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ModDropReplace removes the replace directive of the module old (optionally with an @version suffix) from the
+// go.mod of the given directory. Remember to invoke ModTidy afterwards.
+func ModDropReplace(moduleDir, old string) error {
+	_, err := ModEdit(moduleDir, "-dropreplace="+old)
+
+	return err
+}
+
+// Generate invokes go generate ./... in the given directory. The extraEnv entries (KEY=VALUE) replace those of
+// the inherited environment, just like for ModTidy.
+func Generate(dir string, extraEnv ...string) (string, error) {
 	cmd := exec.Command("go", "generate", "./...")
+	cmd.Env = sanitizeEnv(os.Environ(), hostOverrides(extraEnv))
+	cmd.Dir = dir
+
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("cannot go generate: %s: %w", string(res), err)
+	}
+
+	return strings.TrimSpace(string(res)), nil
+}
+
+// Fmt invokes gofmt -l for the go files of all packages of the module in the given directory and returns the
+// files, which are not formatted. If write is true, gofmt -w is invoked instead, which formats the files in place.
+// Like ./... of the go command, vendor and testdata directories and nested modules are skipped. The returned names
+// are slash separated and relative to dir. If gofmt fails, e.g. due to a syntax error, the files which have been
+// reported anyway are returned together with the error.
+func Fmt(dir string, write bool) ([]string, error) {
+	goFiles, err := packageFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find go files: %w", err)
+	}
+
+	if len(goFiles) == 0 {
+		return nil, nil
+	}
+
+	// -l lists the changed files, also in combination with -w
+	args := []string{"-l"}
+	if write {
+		args = append(args, "-w")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("gofmt", append(args, goFiles...)...)
 	cmd.Env = os.Environ()
 	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	res, err := cmd.Output()
+
+	var files []string
+	for _, line := range strings.Split(string(res), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, filepath.ToSlash(line))
+		}
+	}
+
+	if err != nil {
+		return files, fmt.Errorf("cannot gofmt: %s: %w", stderr.String(), err)
+	}
+
+	return files, nil
+}
+
+// packageFiles returns the go files below dir relative to it, which belong to the packages matched by ./... of the
+// go command. Directories named vendor or testdata, directories and files starting with . or _ and nested modules
+// are skipped.
+func packageFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(fname string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fname == dir {
+			return nil
+		}
+
+		name := info.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() {
+			if name == "vendor" || name == "testdata" {
+				return filepath.SkipDir
+			}
+
+			if _, err := os.Stat(filepath.Join(fname, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if filepath.Ext(name) != ".go" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fname)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, rel)
+
+		return nil
+	})
+
+	return files, err
+}
+
+// GenerateSelective invokes go generate only for the given packages in the given directory.
+func GenerateSelective(dir string, changedPackages []string, extraEnv ...string) (string, error) {
+	args := append([]string{"generate"}, changedPackages...)
+	cmd := exec.Command("go", args...)
+	cmd.Env = sanitizeEnv(os.Environ(), hostOverrides(extraEnv))
+	cmd.Dir = dir
 
 	res, err := cmd.CombinedOutput()
 	if err != nil {
@@ -66,6 +449,49 @@ func Generate(dir string) (string, error) {
 	return strings.TrimSpace(string(res)), nil
 }
 
+// GenerateModules invokes go generate ./... in the directory of each module, which contains at least one of the
+// changed absolute paths. A path belongs to the module with the longest matching Dir, so that nested modules are
+// not generated twice. Modules are generated in the given order.
+func GenerateModules(modules []Module, changedPaths []string, extraEnv ...string) error {
+	changed := map[string]bool{}
+	for _, file := range changedPaths {
+		owner := ""
+		for _, mod := range modules {
+			if mod.Dir == "" || len(mod.Dir) <= len(owner) {
+				continue
+			}
+
+			if file == mod.Dir || strings.HasPrefix(file, mod.Dir+string(filepath.Separator)) {
+				owner = mod.Dir
+			}
+		}
+
+		if owner != "" {
+			changed[owner] = true
+		}
+	}
+
+	for _, mod := range modules {
+		if !changed[mod.Dir] {
+			continue
+		}
+
+		// a module may be listed twice, e.g. if replaced for multiple versions
+		delete(changed, mod.Dir)
+
+		res, err := Generate(mod.Dir, extraEnv...)
+		if err != nil {
+			return fmt.Errorf("unable to generate module %s: %w", mod.Path, err)
+		}
+
+		if Debug && res != "" {
+			log.Println(res)
+		}
+	}
+
+	return nil
+}
+
 // Version returns the go version.
 func Version() (string, error) {
 	cmd := exec.Command("go", "version")
@@ -79,12 +505,116 @@ func Version() (string, error) {
 	return strings.TrimSpace(string(res)), nil
 }
 
+// ParseVersion extracts the semantic version parts from the output of 'go version' (e.g.
+// go version go1.21.0 darwin/amd64) or from a plain version string like 1.21 or go1.21.3. Missing
+// parts are returned as 0 and pre-release suffixes like rc1 or beta2 are ignored.
+func ParseVersion(output string) (major, minor, patch int, err error) {
+	version := ""
+	for _, field := range strings.Fields(output) {
+		field = strings.TrimPrefix(field, "go")
+		if field != "" && field[0] >= '0' && field[0] <= '9' {
+			version = field
+			break
+		}
+	}
+
+	if version == "" {
+		return 0, 0, 0, fmt.Errorf("no go version found in '%s'", output)
+	}
+
+	var parts [3]int
+	for i, part := range strings.SplitN(version, ".", 3) {
+		// cut off things like rc1 or beta2
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+
+		if end == 0 {
+			return 0, 0, 0, fmt.Errorf("invalid go version '%s'", version)
+		}
+
+		parts[i], err = strconv.Atoi(part[:end])
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid go version '%s': %w", version, err)
+		}
+
+		if end != len(part) {
+			break
+		}
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+// CheckMinVersion returns an error, if the installed go version is older than the given minimum version,
+// e.g. 1.21 or 1.21.3.
+func CheckMinVersion(min string) error {
+	minMajor, minMinor, minPatch, err := ParseVersion(min)
+	if err != nil {
+		return fmt.Errorf("unable to parse required go version: %w", err)
+	}
+
+	str, err := Version()
+	if err != nil {
+		return err
+	}
+
+	major, minor, patch, err := ParseVersion(str)
+	if err != nil {
+		return fmt.Errorf("unable to parse installed go version: %w", err)
+	}
+
+	if Debug {
+		log.Println(fmt.Sprintf("go version: installed %d.%d.%d, required %d.%d.%d", major, minor, patch, minMajor, minMinor, minPatch))
+	}
+
+	if major != minMajor {
+		if major > minMajor {
+			return nil
+		}
+	} else if minor != minMinor {
+		if minor > minMinor {
+			return nil
+		}
+	} else if patch >= minPatch {
+		return nil
+	}
+
+	return fmt.Errorf("go %s or newer is required, but installed is '%s'", min, str)
+}
+
+// ModGoVersion returns the version of the go directive from the go.mod file in the given directory.
+// If the go.mod does not declare a go directive, the empty string is returned.
+func ModGoVersion(dir string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("unable to open go.mod: %w", err)
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "go" {
+			return fields[1], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("unable to read go.mod: %w", err)
+	}
+
+	return "", nil
+}
+
 // ModList returns all local folders to each correct dependency version. The first
 // returned directory is the main directory.
-func ModList(moduleDir string) ([]Module, error) {
+func ModList(moduleDir string, extraEnv ...string) ([]Module, error) {
 	cmd := exec.Command("go", "list", "-m", "-json", "all")
 	cmd.Dir = moduleDir
-	cmd.Env = os.Environ()
+	cmd.Env = sanitizeEnv(os.Environ(), hostOverrides(extraEnv))
 
 	res, err := cmd.CombinedOutput()
 	if err != nil {
@@ -115,12 +645,43 @@ func ModList(moduleDir string) ([]Module, error) {
 	return modules, nil
 }
 
+// PackageList returns all packages of the module in the given directory. The extraEnv entries (KEY=VALUE)
+// replace those of the inherited environment, just like for ModTidy.
+func PackageList(moduleDir string, extraEnv ...string) ([]Package, error) {
+	cmd := exec.Command("go", "list", "-json", "./...")
+	cmd.Dir = moduleDir
+	cmd.Env = sanitizeEnv(os.Environ(), hostOverrides(extraEnv))
+
+	res, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list packages: %w: %s", err, string(res))
+	}
+
+	str := "[" + strings.ReplaceAll(string(res), "}\n{", "},\n{") + "]"
+
+	var packages []Package
+	if err := json.Unmarshal([]byte(str), &packages); err != nil {
+		return nil, fmt.Errorf("unable grab results: %w", err)
+	}
+
+	return packages, nil
+}
+
 // BuildWasm builds an idiomatic wasm go module. The wasm main entry point must be defined at cmd/wasm. The
-// output file is forwarded.
-func BuildWasm(mod Module, outFile string) error {
+// output file is forwarded. Empty goos and goarch default to js and wasm. Goflags is optional, see Options.GOFlags.
+func BuildWasm(mod Module, outFile, goos, goarch, goflags string) error {
+	if goos == "" {
+		goos = "js"
+	}
+
+	if goarch == "" {
+		goarch = "wasm"
+	}
+
 	err := Build(Options{
-		GOOS:       "js",
-		GOARCH:     "wasm",
+		GOOS:       goos,
+		GOARCH:     goarch,
+		GOFlags:    goflags,
 		WorkingDir: mod.Dir,
 		Output:     outFile,
 		Packages:   []string{mod.Path + "/cmd/wasm"}, // this is our convention
@@ -136,6 +697,89 @@ func BuildWasm(mod Module, outFile string) error {
 	return nil
 }
 
+// ProfileMem is the heap profile type of BuildWasmWithProfile. There is no cpu profile type, because the wasm
+// runtime has no profiling signal and pprof.StartCPUProfile would record nothing.
+const ProfileMem = "mem"
+
+// BuildWasmWithProfile builds like BuildWasm, but injects a profiler into the cmd/wasm main package by an
+// overlay and sets the profile build tag. The web assembly exports the JavaScript functions startProfile and
+// stopProfile, which returns the recorded pprof profile as Uint8Array. The profileType must be ProfileMem.
+// The profiler requires syscall/js, so goos must be js or empty. The source tree is not touched. Requires Go 1.16.
+func BuildWasmWithProfile(mod Module, outFile, goos, goarch, profileType, goflags string) error {
+	if profileType != ProfileMem {
+		return fmt.Errorf("invalid profile type: '%s': only %s is supported by the wasm runtime", profileType, ProfileMem)
+	}
+
+	if goos == "" {
+		goos = "js"
+	}
+
+	if goarch == "" {
+		goarch = "wasm"
+	}
+
+	if goos != "js" {
+		return fmt.Errorf("unable to inject profiler: requires syscall/js, which is not available for GOOS=%s", goos)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "gotrino-profile")
+	if err != nil {
+		return fmt.Errorf("unable to create overlay dir: %w", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	overlayFile := filepath.Join(tmpDir, "overlay.json")
+	profilerFile := filepath.Join(mod.Dir, "cmd", "wasm", "gotrino_profile.go")
+	if err := WriteOverlay(map[string]string{profilerFile: profilerSource}, overlayFile); err != nil {
+		return err
+	}
+
+	return Build(Options{
+		GOOS:        goos,
+		GOARCH:      goarch,
+		GOFlags:     goflags,
+		WorkingDir:  mod.Dir,
+		Output:      outFile,
+		Packages:    []string{mod.Path + "/cmd/wasm"},
+		OverlayFile: overlayFile,
+		Tags:        []string{"profile"},
+	})
+}
+
+// profilerSource is injected into the main package by BuildWasmWithProfile.
+const profilerSource = `package main
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"syscall/js"
+)
+
+var gotrinoProfile bytes.Buffer
+
+func init() {
+	js.Global().Set("startProfile", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		gotrinoProfile.Reset()
+
+		return nil
+	}))
+
+	js.Global().Set("stopProfile", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(&gotrinoProfile); err != nil {
+			return err.Error()
+		}
+
+		buf := js.Global().Get("Uint8Array").New(gotrinoProfile.Len())
+		js.CopyBytesToJS(buf, gotrinoProfile.Bytes())
+
+		return buf
+	}))
+}
+`
+
 // Options represent the various build options for the go build command.
 type Options struct {
 	GOOS       string
@@ -145,6 +789,13 @@ type Options struct {
 	Packages   []string
 	Env        []string
 	LDFLAGS    LDFLAGS
+	// OverlayFile is passed as -overlay to inject files without touching the source tree. Requires Go 1.16.
+	// See also WriteOverlay.
+	OverlayFile string
+	// GOFlags replaces an inherited GOFLAGS environment variable, if not empty, e.g. -modcacherw.
+	GOFlags string
+	// Tags are passed as comma separated -tags.
+	Tags []string
 }
 
 // LDFLAGS represent the go linker flags.
@@ -177,25 +828,40 @@ func Build(opts Options) error {
 		args = append(args, "-o", opts.Output)
 	}
 
+	if opts.OverlayFile != "" {
+		args = append(args, "-overlay="+opts.OverlayFile)
+	}
+
+	if len(opts.Tags) > 0 {
+		args = append(args, "-tags="+strings.Join(opts.Tags, ","))
+	}
+
 	for _, p := range opts.Packages {
 		args = append(args, p)
 	}
 
 	cmd := exec.Command("go", args...)
 	cmd.Dir = opts.WorkingDir
-	cmd.Env = opts.Env
-	if len(cmd.Env) == 0 {
-		cmd.Env = append(cmd.Env, os.Environ()...)
+	env := opts.Env
+	if len(env) == 0 {
+		env = os.Environ()
 	}
 
+	overrides := map[string]string{}
 	if opts.GOOS != "" {
-		cmd.Env = append(cmd.Env, "GOOS="+opts.GOOS)
+		overrides["GOOS"] = opts.GOOS
 	}
 
 	if opts.GOARCH != "" {
-		cmd.Env = append(cmd.Env, "GOARCH="+opts.GOARCH)
+		overrides["GOARCH"] = opts.GOARCH
 	}
 
+	if opts.GOFlags != "" {
+		overrides["GOFLAGS"] = opts.GOFlags
+	}
+
+	cmd.Env = sanitizeEnv(env, overrides)
+
 	res, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("%w: %s", err, string(res))
@@ -204,6 +870,56 @@ func Build(opts Options) error {
 	return nil
 }
 
+// WriteOverlay writes the json file expected by go build -overlay. The files map contains the path of a
+// source file and its content. The path may refer to a non-existing file, which is added to the build.
+// The contents are written into a directory next to dst, named like dst with a .d suffix.
+func WriteOverlay(files map[string]string, dst string) error {
+	type overlay struct {
+		Replace map[string]string
+	}
+
+	contentDir := dst + ".d"
+	if err := os.RemoveAll(contentDir); err != nil {
+		return fmt.Errorf("unable to remove old overlay files: %w", err)
+	}
+
+	if err := os.MkdirAll(contentDir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create overlay dir: %w", err)
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	o := overlay{Replace: map[string]string{}}
+	for i, path := range paths {
+		contentFile, err := filepath.Abs(filepath.Join(contentDir, strconv.Itoa(i)+"_"+filepath.Base(path)))
+		if err != nil {
+			return fmt.Errorf("unable to determine absolute overlay file: %w", err)
+		}
+
+		if err := ioutil.WriteFile(contentFile, []byte(files[path]), os.ModePerm); err != nil {
+			return fmt.Errorf("unable to write overlay file: %w", err)
+		}
+
+		o.Replace[path] = contentFile
+	}
+
+	buf, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal overlay: %w", err)
+	}
+
+	if err := ioutil.WriteFile(dst, buf, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to write overlay: %w", err)
+	}
+
+	return nil
+}
+
 // Env requests the given parameter name.
 func Env(name string) (string, error) {
 	cmd := exec.Command("go", "env", name)
@@ -0,0 +1,412 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in                  string
+		major, minor, patch int
+		fail                bool
+	}{
+		{in: "go version go1.21.0 darwin/amd64", major: 1, minor: 21},
+		{in: "go version go1.15.6 linux/amd64", major: 1, minor: 15, patch: 6},
+		{in: "go version go1.22rc1 linux/amd64", major: 1, minor: 22},
+		{in: "1.21", major: 1, minor: 21},
+		{in: "go1.20.3", major: 1, minor: 20, patch: 3},
+		{in: "go version devel", fail: true},
+		{in: "", fail: true},
+	}
+
+	for _, tt := range tests {
+		major, minor, patch, err := ParseVersion(tt.in)
+		if (err != nil) != tt.fail {
+			t.Fatalf("%s: unexpected error state: %v", tt.in, err)
+		}
+
+		if major != tt.major || minor != tt.minor || patch != tt.patch {
+			t.Fatalf("%s: expected %d.%d.%d but got %d.%d.%d", tt.in, tt.major, tt.minor, tt.patch, major, minor, patch)
+		}
+	}
+}
+
+func Test_diffLines(t *testing.T) {
+	a := "module a\n\nrequire (\n\tb v1.0.0\n\tc v1.0.0\n)\n"
+	b := "module a\n\nrequire (\n\tb v1.0.0\n\td v1.2.0\n)\n"
+
+	diffs := DiffLines(a, b)
+	if len(diffs) != 2 || diffs[0] != "- \tc v1.0.0" || diffs[1] != "+ \td v1.2.0" {
+		t.Fatalf("unexpected diff: %q", diffs)
+	}
+
+	if diffs := DiffLines(a, a); len(diffs) != 0 {
+		t.Fatalf("expected no diff but got %q", diffs)
+	}
+}
+
+func TestModPinInvalid(t *testing.T) {
+	for _, args := range [][2]string{{"", "v1.0.0"}, {"example.com/a@v1", "v1.0.0"}, {"example.com/a", ""}, {"example.com/a", "v1 v2"}, {"-modfile=x", "v1.0.0"}, {"example.com/a", "-v1.0.0"}} {
+		if err := ModPin(".", args[0], args[1]); err == nil {
+			t.Fatalf("expected %q to be rejected", args)
+		}
+	}
+}
+
+func TestPackageList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.mod":   "module example.com/list\n\ngo 1.16\n",
+		"a/a.go":   "package a\n",
+		"b/b.go":   "package b\n\nimport _ \"example.com/list/a\"\n",
+		"js/js.go": "//go:build js\n\npackage js\n",
+	}
+
+	for name, content := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an inherited wasm target must not change the listed packages
+	for key, value := range map[string]string{"GOOS": "js", "GOARCH": "wasm"} {
+		if old, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packages, err := PackageList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(packages) != 2 || packages[0].ImportPath != "example.com/list/a" || packages[1].ImportPath != "example.com/list/b" {
+		t.Fatalf("unexpected packages: %+v", packages)
+	}
+
+	if !reflect.DeepEqual(packages[1].Imports, []string{"example.com/list/a"}) || !reflect.DeepEqual(packages[1].GoFiles, []string{"b.go"}) {
+		t.Fatalf("unexpected package b: %+v", packages[1])
+	}
+
+	// the extra environment still takes precedence
+	packages, err = PackageList(dir, "GOFLAGS=-tags=js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(packages) != 3 || packages[2].ImportPath != "example.com/list/js" {
+		t.Fatalf("expected the js package to be listed by its build tag but got %+v", packages)
+	}
+}
+
+func TestWriteOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "overlay.json")
+	if err := WriteOverlay(map[string]string{"/src/gen.go": "package src"}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var overlay struct {
+		Replace map[string]string
+	}
+
+	if err := json.Unmarshal(buf, &overlay); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(overlay.Replace["/src/gen.go"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "package src" {
+		t.Fatalf("unexpected overlay content: %s", string(content))
+	}
+}
+
+func TestBuildWasmWithProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.mod":           "module example.com/profile\n\ngo 1.16\n",
+		"cmd/wasm/main.go": "package main\n\nfunc main() {}\n",
+	}
+
+	for name, content := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mod := Module{Path: "example.com/profile", Dir: dir}
+	for _, profileType := range []string{"trace", "cpu"} {
+		if err := BuildWasmWithProfile(mod, filepath.Join(dir, "app.wasm"), "", "", profileType, ""); err == nil {
+			t.Fatalf("expected the profile type %s to fail", profileType)
+		}
+	}
+
+	if err := BuildWasmWithProfile(mod, filepath.Join(dir, "app.wasm"), "wasip1", "wasm", ProfileMem, ""); err == nil {
+		t.Fatal("expected wasip1 to fail without syscall/js")
+	}
+
+	if err := BuildWasmWithProfile(mod, filepath.Join(dir, "app.wasm"), "", "", ProfileMem, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "cmd", "wasm", "gotrino_profile.go")); !os.IsNotExist(err) {
+		t.Fatalf("expected the source tree to be untouched but got %v", err)
+	}
+}
+
+func TestSanitizeEnv(t *testing.T) {
+	env := []string{"HOME=/root", "GOOS=linux", "GOARCH=amd64", "GOOS=windows"}
+	got := sanitizeEnv(env, map[string]string{"GOOS": "js", "GOARCH": "wasm"})
+	want := []string{"HOME=/root", "GOARCH=wasm", "GOOS=js"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v but got %v", want, got)
+	}
+
+	got = sanitizeEnv(env, hostTarget)
+	if !reflect.DeepEqual(got, []string{"HOME=/root"}) {
+		t.Fatalf("expected the target to be removed but got %v", got)
+	}
+
+	got = sanitizeEnv(env, hostOverrides([]string{"GOARCH=arm64", "GONOSUMDB=*.example.com"}))
+	want = []string{"HOME=/root", "GOARCH=arm64", "GONOSUMDB=*.example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the extra env to win but got %v", got)
+	}
+}
+
+func TestGenerateModules(t *testing.T) {
+	if testing.Short() {
+		t.Skip("invokes the go toolchain")
+	}
+
+	root, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	// the nested module owns its own files, so only it must be generated
+	outer := Module{Path: "example.com/outer", Dir: root}
+	nested := Module{Path: "example.com/nested", Dir: filepath.Join(root, "nested")}
+	for _, mod := range []Module{outer, nested} {
+		files := map[string]string{
+			"go.mod": "module " + mod.Path + "\n\ngo 1.15\n",
+			"gen.go": "// +build ignore\n\npackage main\n\nimport \"io/ioutil\"\n\nfunc main() {\n\t_ = ioutil.WriteFile(\"generated.txt\", nil, 0644)\n}\n",
+			"doc.go": "//go:generate go run gen.go\n\npackage doc\n",
+		}
+
+		if err := os.MkdirAll(mod.Dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(mod.Dir, name), []byte(content), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := GenerateModules([]Module{outer, nested}, []string{filepath.Join(nested.Dir, "doc.go")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(nested.Dir, "generated.txt")); err != nil {
+		t.Fatalf("expected the nested module to be generated: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outer.Dir, "generated.txt")); err == nil {
+		t.Fatal("expected the outer module not to be generated")
+	}
+}
+
+func TestFmt(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "fmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// only pkg/a.go belongs to the module, the other files are neither formatted nor fail the run
+	sources := map[string]string{
+		"go.mod":             "module example.com/fmt\n",
+		"pkg/a.go":           "package pkg\nvar  a=1\n",
+		"vendor/x/b.go":      "package x\nvar  b=1\n",
+		"testdata/broken.go": "package broken\nfunc {\n",
+		"nested/go.mod":      "module example.com/nested\n",
+		"nested/c.go":        "package nested\nvar  c=1\n",
+	}
+
+	for name, content := range sources {
+		fname := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, write := range []bool{false, true} {
+		files, err := Fmt(dir, write)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(files, []string{"pkg/a.go"}) {
+			t.Fatalf("expected pkg/a.go but got %v", files)
+		}
+	}
+
+	formatted, err := Fmt(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(formatted) != 0 {
+		t.Fatalf("expected formatted files but got %v", formatted)
+	}
+
+	// a syntax error fails, but the other formatted files are still reported
+	if err := ioutil.WriteFile(filepath.Join(dir, "pkg", "a.go"), []byte("package pkg\nvar  a=1\n"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "pkg", "z.go"), []byte("package pkg\nfunc {\n"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	formatted, err = Fmt(dir, true)
+	if err == nil {
+		t.Fatal("expected the syntax error to fail")
+	}
+
+	if !reflect.DeepEqual(formatted, []string{"pkg/a.go"}) {
+		t.Fatalf("expected pkg/a.go but got %v", formatted)
+	}
+}
+
+func TestCheckModEdit(t *testing.T) {
+	tests := []struct {
+		edit string
+		fail bool
+	}{
+		{edit: "-require=example.com/a@v1.2.3"},
+		{edit: "--droprequire=example.com/a"},
+		{edit: "-replace=example.com/a=../a"},
+		{edit: "-dropreplace=example.com/a@v1.0.0"},
+		{edit: "-retract=v1.0.1"},
+		{edit: "-dropretract=[v1.0.0,v1.0.5]"},
+		{edit: "-fmt"},
+		{edit: "require=example.com/a@v1.2.3", fail: true},
+		{edit: "-require", fail: true},
+		{edit: "-require=", fail: true},
+		{edit: "-replace=example.com/a", fail: true},
+		{edit: "-fmt=true", fail: true},
+		{edit: "-json", fail: true},
+		{edit: "-", fail: true},
+	}
+
+	for _, tt := range tests {
+		if err := checkModEdit(tt.edit); (err != nil) != tt.fail {
+			t.Fatalf("%s: expected failure %v but got %v", tt.edit, tt.fail, err)
+		}
+	}
+}
+
+func TestModEdit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modedit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/b\n\ngo 1.15\n"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ModEdit(dir, "-require=example.com/a@v1.2.3", "-replace=example.com/a=../a"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"require example.com/a v1.2.3", "replace example.com/a => ../a"} {
+		if !strings.Contains(string(buf), line) {
+			t.Fatalf("expected '%s' in go.mod but got %s", line, string(buf))
+		}
+	}
+}
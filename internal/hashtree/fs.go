@@ -0,0 +1,100 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashtree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/worldiety/go-tip/1.16/io/fs"
+	"io"
+	"os"
+	"path"
+)
+
+// HashFS calculates the hash tree of the entire root. In contrast to ReadDir, dotted names are not ignored,
+// because they are usually relevant for deployments (e.g. .htaccess), and every file is read, because there is
+// no former tree to compare with.
+func HashFS(root fs.ReadDirFS) (*Node, error) {
+	node := NewNode()
+	if err := hashFS(root, ".", node); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func hashFS(root fs.ReadDirFS, dir string, parent *Node) error {
+	entries, err := root.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("unable to list directory: '%s': %w", dir, err)
+	}
+
+	hasher := sha256.New()
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		node := &Node{
+			Name: entry.Name(),
+			Mode: os.FileMode(entry.Type()),
+		}
+
+		if entry.IsDir() {
+			if err := hashFS(root, name, node); err != nil {
+				return err
+			}
+		} else {
+			h, err := readFS(root, name)
+			if err != nil {
+				return fmt.Errorf("unable to calculate file hash sum: '%s': %w", name, err)
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return fmt.Errorf("unable to stat file: '%s': %w", name, err)
+			}
+
+			node.Hash = h
+			node.Size = info.Size()
+		}
+
+		parent.Add(node)
+		parent.Size += node.Size
+
+		if _, err := hasher.Write(node.Hash[:]); err != nil {
+			return fmt.Errorf("unable to hash node: %w", err)
+		}
+	}
+
+	copy(parent.Hash[:], hasher.Sum(nil))
+
+	return nil
+}
+
+// readFS is like Read but for a file from the given filesystem.
+func readFS(root fs.FS, name string) (r [32]byte, err error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return r, err
+	}
+
+	defer try(f.Close, &err)
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return r, err
+	}
+
+	copy(r[:], h.Sum(nil))
+
+	return r, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashtree
+
+import (
+	"crypto/sha256"
+	"github.com/zeebo/xxh3"
+	"hash"
+)
+
+// A Hasher calculates the hash sum of all written bytes.
+type Hasher interface {
+	Write(p []byte) (int, error)
+	// Sum32 returns the hash sum. Shorter sums are padded with zeros.
+	Sum32() [32]byte
+}
+
+// A HasherFactory creates a new Hasher for each file and directory.
+type HasherFactory func() Hasher
+
+// SHA256Factory creates sha256 hashers. It is the default.
+func SHA256Factory() Hasher {
+	return sha256Hasher{sha256.New()}
+}
+
+// XXH3Factory creates 128 bit xxh3 hashers. They are much faster than sha256 but not cryptographically secure,
+// which is fine for change detection.
+func XXH3Factory() Hasher {
+	return xxh3Hasher{xxh3.New()}
+}
+
+type sha256Hasher struct {
+	hash.Hash
+}
+
+func (h sha256Hasher) Sum32() (r [32]byte) {
+	copy(r[:], h.Sum(nil))
+	return r
+}
+
+type xxh3Hasher struct {
+	*xxh3.Hasher
+}
+
+func (h xxh3Hasher) Sum32() (r [32]byte) {
+	sum := h.Sum128().Bytes()
+	copy(r[:], sum[:])
+	return r
+}
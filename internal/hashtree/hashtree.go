@@ -15,7 +15,6 @@
 package hashtree
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"github.com/golangee/log"
@@ -33,7 +32,7 @@ var Debug = false
 // File should represent a real physical file with the given meta data. It still virtual, as the file may not exist.
 type File struct {
 	Prefix   string // Prefix is a constant
-	Filename string // Filename is a relative but full file name
+	Filename string // Filename is a relative but full file name, which is always slash separated.
 	Node     *Node
 }
 
@@ -44,6 +43,7 @@ type Node struct {
 	Name     string
 	Mode     os.FileMode
 	ModTime  time.Time
+	Size     int64 // Size is the file size in bytes or the sum of all children for a directory.
 	Children []*Node
 }
 
@@ -51,6 +51,23 @@ func NewNode() *Node {
 	return &Node{}
 }
 
+// Clone returns a deep copy of the entire subtree, so that later updates of n are not reflected in the copy.
+func (n *Node) Clone() *Node {
+	if n == nil {
+		return nil
+	}
+
+	c := *n
+	if n.Children != nil {
+		c.Children = make([]*Node, len(n.Children))
+		for i, child := range n.Children {
+			c.Children[i] = child.Clone()
+		}
+	}
+
+	return &c
+}
+
 // Flatten returns hashtree files with absolute file names according to the given root. The array is sorted ascending.
 func (n *Node) Flatten(prefix string) []File {
 	return n.flatten(prefix, "")
@@ -61,7 +78,7 @@ func (n *Node) flatten(prefix, root string) []File {
 
 	res = append(res, File{
 		Prefix:   prefix,
-		Filename: filepath.Join(root, n.Name),
+		Filename: filepath.ToSlash(filepath.Join(root, n.Name)),
 		Node:     n,
 	})
 
@@ -125,24 +142,25 @@ func (n *Node) Add(child *Node) {
 	})
 }
 
-// Read just calculates the sha256 value for a single file.
-func Read(fname string) (r [32]byte, err error) {
+// Read just calculates the hash value for a single file. If newHasher is nil, SHA256Factory is used.
+func Read(fname string, newHasher HasherFactory) (r [32]byte, err error) {
+	if newHasher == nil {
+		newHasher = SHA256Factory
+	}
+
 	f, err := os.OpenFile(fname, os.O_RDONLY, 0)
 	if err != nil {
 		return r, err
 	}
 
 	defer try(f.Close, &err)
-	h := sha256.New()
+	h := newHasher()
 	if _, err = io.Copy(h, f); err != nil {
 		_ = f.Close() // read-only err not of interest
 		return r, err
 	}
 
-	tmp := h.Sum(nil)
-	copy(r[:], tmp)
-
-	return r, nil
+	return h.Sum32(), nil
 }
 
 // ReadDir walks in sorted order from root to any leaf. It ignores anything starting with a dot.
@@ -150,19 +168,148 @@ func Read(fname string) (r [32]byte, err error) {
 // ever read leaf-files if they are unknown or if the ModTime is different. Extra in-memory nodes are
 // removed, if they are not present in the filesystem anymore. Note that this performance improvement will
 // fail on systems where the ModTime is not updated or the timer resolution is not small enough.
-func ReadDir(rootDir string, parent *Node) error {
+// If symlinks is true, symbolic links are treated like their targets, so that symlinked directories are
+// descended. A link to one of its own parent directories is ignored, to avoid endless cycles.
+// All files and directories are hashed by newHasher, which defaults to SHA256Factory if nil. The same
+// factory must be used for all reads of a tree, otherwise unchanged files are mixed with other sums.
+func ReadDir(rootDir string, parent *Node, symlinks bool, newHasher HasherFactory) error {
+	return ReadDirWithProgress(rootDir, parent, symlinks, newHasher, nil)
+}
+
+// A ProgressFunc is notified after each file of a ReadDirWithProgress, regardless if it has been read or not.
+type ProgressFunc func(filesHashed, totalFiles int, currentPath string)
+
+// ReadDirWithProgress is like ReadDir but invokes the optional progress after each file. To calculate the
+// total, all directories are listed once before.
+func ReadDirWithProgress(rootDir string, parent *Node, symlinks bool, newHasher HasherFactory, progress ProgressFunc) error {
+	w := &walk{newHasher: newHasher, progress: progress}
+	if w.newHasher == nil {
+		w.newHasher = SHA256Factory
+	}
+
+	if symlinks {
+		realDir, err := filepath.EvalSymlinks(rootDir)
+		if err != nil {
+			return fmt.Errorf("unable to resolve directory: '%s': %w", rootDir, err)
+		}
+
+		w.ancestors = map[string]bool{realDir: true}
+	}
+
+	if progress != nil {
+		w.total = w.countFiles(rootDir)
+	}
+
+	return w.readDir(rootDir, parent)
+}
+
+// walk contains the state of a single ReadDirWithProgress.
+type walk struct {
+	// ancestors contains the resolved paths of all parent directories and is nil, if symbolic links are not followed.
+	ancestors map[string]bool
+	newHasher HasherFactory
+	progress  ProgressFunc
+	hashed    int // hashed is the amount of files processed so far.
+	total     int // total is the amount of files found by countFiles.
+}
+
+// entries returns the files of the directory, which are not ignored. Symbolic links are resolved, if followed,
+// and links to an ancestor or unresolvable links are omitted. The resolved paths are returned as well.
+func (w *walk) entries(rootDir string) ([]os.FileInfo, []string, error) {
 	files, err := ioutil.ReadDir(rootDir)
 	if err != nil {
-		return fmt.Errorf("unable to list directory: '%s': %w", rootDir, err)
+		return nil, nil, fmt.Errorf("unable to list directory: '%s': %w", rootDir, err)
 	}
 
-	hasher := sha256.New()
-	var currentFiles []string
+	var res []os.FileInfo
+	var realPaths []string
 	for _, file := range files {
 		if fileIgnored(file.Name()) {
 			continue
 		}
 
+		realPath := ""
+		if w.ancestors != nil {
+			realPath, file, err = resolveSymlink(filepath.Join(rootDir, file.Name()), file)
+			if err != nil {
+				if Debug {
+					log.Println(fmt.Sprintf("hashtree: %s: ignoring unresolvable link %s: %v", rootDir, file.Name(), err))
+				}
+
+				continue
+			}
+
+			if file.IsDir() && w.ancestors[realPath] {
+				if Debug {
+					log.Println(fmt.Sprintf("hashtree: %s: ignoring cyclic link %s -> %s", rootDir, file.Name(), realPath))
+				}
+
+				continue
+			}
+		}
+
+		res = append(res, file)
+		realPaths = append(realPaths, realPath)
+	}
+
+	return res, realPaths, nil
+}
+
+// descend invokes f for the given directory, while it is registered as an ancestor.
+func (w *walk) descend(realPath string, f func() error) error {
+	if w.ancestors == nil {
+		return f()
+	}
+
+	w.ancestors[realPath] = true
+	defer delete(w.ancestors, realPath)
+
+	return f()
+}
+
+// countFiles returns the amount of regular files, which readDir will process. Unreadable directories are
+// not counted, because readDir fails on them anyway.
+func (w *walk) countFiles(rootDir string) int {
+	files, realPaths, err := w.entries(rootDir)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for i, file := range files {
+		if file.Mode().IsRegular() {
+			count++
+		} else if file.IsDir() {
+			_ = w.descend(realPaths[i], func() error {
+				count += w.countFiles(filepath.Join(rootDir, file.Name()))
+				return nil
+			})
+		}
+	}
+
+	return count
+}
+
+// fileDone notifies the progress about a processed file.
+func (w *walk) fileDone(path string) {
+	if w.progress == nil {
+		return
+	}
+
+	w.hashed++
+	w.progress(w.hashed, w.total, path)
+}
+
+// readDir implements ReadDirWithProgress.
+func (w *walk) readDir(rootDir string, parent *Node) error {
+	files, realPaths, err := w.entries(rootDir)
+	if err != nil {
+		return err
+	}
+
+	hasher := w.newHasher()
+	var currentFiles []string
+	for i, file := range files {
 		currentFiles = append(currentFiles, file.Name())
 		absolutePath := filepath.Join(rootDir, file.Name())
 		node := parent.Find(file.Name())
@@ -177,6 +324,8 @@ func ReadDir(rootDir string, parent *Node) error {
 				return fmt.Errorf("unable to hash node: %w", err)
 			}
 
+			w.fileDone(absolutePath)
+
 			continue
 		}
 
@@ -189,9 +338,10 @@ func ReadDir(rootDir string, parent *Node) error {
 		}
 
 		node.ModTime = file.ModTime()
+		node.Size = file.Size()
 
 		if file.Mode().IsRegular() {
-			h, err := Read(absolutePath)
+			h, err := Read(absolutePath, w.newHasher)
 			if err != nil {
 				return fmt.Errorf("unable to calculate file hash sum")
 			}
@@ -201,8 +351,13 @@ func ReadDir(rootDir string, parent *Node) error {
 			}
 
 			node.Hash = h
+			w.fileDone(absolutePath)
 		} else if file.IsDir() {
-			if err := ReadDir(absolutePath, node); err != nil {
+			err := w.descend(realPaths[i], func() error {
+				return w.readDir(absolutePath, node)
+			})
+
+			if err != nil {
 				return fmt.Errorf("unable to read node dir: %w", err)
 			}
 		}
@@ -228,9 +383,13 @@ func ReadDir(rootDir string, parent *Node) error {
 		}
 	}
 
+	parent.Size = 0
+	for _, child := range parent.Children {
+		parent.Size += child.Size
+	}
+
 	// update merkle root hash
-	tmp := hasher.Sum(nil)
-	copy(parent.Hash[:], tmp)
+	parent.Hash = hasher.Sum32()
 
 	if Debug {
 		log.Println(fmt.Sprintf("hashtree: dir %s => %s", rootDir, hex.EncodeToString(parent.Hash[:])))
@@ -239,6 +398,30 @@ func ReadDir(rootDir string, parent *Node) error {
 	return nil
 }
 
+// resolveSymlink returns the info of the link target, if the given file is a symbolic link. For directories,
+// the resolved path is returned as well.
+func resolveSymlink(path string, file os.FileInfo) (string, os.FileInfo, error) {
+	if file.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			return "", file, err
+		}
+
+		file = target
+	}
+
+	if !file.IsDir() {
+		return "", file, nil
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", file, err
+	}
+
+	return realPath, file, nil
+}
+
 // fileIgnored currently only returns false for dotted names (. prefix).
 func fileIgnored(name string) bool {
 	if len(name) == 0 || name[0] == '.' {
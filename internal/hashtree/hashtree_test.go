@@ -0,0 +1,260 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashtree
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// newTree creates a tree with 10 directories per level and 10 files per leaf directory.
+func newTree(depth int) *Node {
+	root := &Node{Mode: os.ModeDir}
+	for i := 0; i < 10; i++ {
+		var child *Node
+		if depth == 0 {
+			child = &Node{Name: strconv.Itoa(i)}
+			child.Hash[0] = byte(i)
+		} else {
+			child = newTree(depth - 1)
+			child.Name = strconv.Itoa(i)
+		}
+
+		root.Children = append(root.Children, child)
+	}
+
+	return root
+}
+
+func TestNode_Clone(t *testing.T) {
+	tree := newTree(2)
+	c := tree.Clone()
+
+	tree.Children[0].Children[0].Children[0].Hash[0] = 42
+	tree.Children[1].Children = nil
+
+	if c.Children[0].Children[0].Children[0].Hash[0] != 0 {
+		t.Fatal("hash of clone has been modified")
+	}
+
+	if len(c.Children[1].Children) != 10 {
+		t.Fatal("children of clone have been modified")
+	}
+
+	if len(c.Flatten("")) != 1111 {
+		t.Fatalf("unexpected amount of nodes: %d", len(c.Flatten("")))
+	}
+}
+
+func TestWalkChanged(t *testing.T) {
+	old := newTree(2)
+	new := old.Clone()
+	new.Children[0].Children[0].Children[0].Hash[0] = 42 // modified
+	new.Children[1].RemoveAt(0)                          // removes 10 files
+	new.Children[2].Children[0].Children[1] = &Node{Name: "1", Mode: os.ModeDir, Children: []*Node{{Name: "x"}}}
+	new.Add(&Node{Name: "new"})
+
+	kinds := map[ChangeKind]int{}
+	var paths []string
+	err := WalkChanged(old, new, func(path string, kind ChangeKind, hash [32]byte) error {
+		kinds[kind]++
+		paths = append(paths, path)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file 2/0/1 has become a directory
+	if kinds[Added] != 2 || kinds[Modified] != 1 || kinds[Removed] != 11 {
+		t.Fatalf("unexpected changes: %v", kinds)
+	}
+
+	sort.Strings(paths)
+	want := []string{"0/0/0", "1/0/0", "1/0/1", "1/0/2", "1/0/3", "1/0/4", "1/0/5", "1/0/6", "1/0/7", "1/0/8", "1/0/9", "2/0/1", "2/0/1/x", "new"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected %v but got %v", want, paths)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = WalkChanged(nil, new, func(path string, kind ChangeKind, hash [32]byte) error {
+		calls++
+		return stop
+	})
+
+	if err != stop || calls != 1 {
+		t.Fatalf("expected to stop after the first call but got %d calls: %v", calls, err)
+	}
+}
+
+// BenchmarkNode_Clone clones a tree of 11 111 nodes.
+func BenchmarkNode_Clone(b *testing.B) {
+	tree := newTree(3)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Clone()
+	}
+}
+
+func TestReadDirSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	shared := filepath.Join(dir, "shared")
+	root := filepath.Join(dir, "static")
+	for _, d := range []string{shared, root} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(shared, "main.css"), []byte("body{}"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(shared, filepath.Join(root, "css")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(root, filepath.Join(root, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	node := NewNode()
+	if err := ReadDir(root, node, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if node.Find("css") != nil && node.Find("css").Find("main.css") != nil {
+		t.Fatal("expected symlinked directory to be ignored")
+	}
+
+	node = NewNode()
+	if err := ReadDir(root, node, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	css := node.Find("css")
+	if css == nil || !css.Mode.IsDir() || css.Find("main.css") == nil {
+		t.Fatalf("expected symlinked directory to be descended: %+v", node)
+	}
+
+	if node.Find("loop") != nil {
+		t.Fatal("expected cyclic link to be ignored")
+	}
+}
+
+// newFileTree writes 10 directories with 100 files of 4 KB each.
+func newFileTree(b *testing.B) string {
+	root, err := ioutil.TempDir("", "hashtree")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	for i := 0; i < 10; i++ {
+		dir := filepath.Join(root, strconv.Itoa(i))
+		if err := os.Mkdir(dir, os.ModePerm); err != nil {
+			b.Fatal(err)
+		}
+
+		for j := 0; j < 100; j++ {
+			buf[0] = byte(j)
+			if err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(j)), buf, os.ModePerm); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	return root
+}
+
+func benchmarkReadDir(b *testing.B, newHasher HasherFactory) {
+	root := newFileTree(b)
+	defer os.RemoveAll(root)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ReadDir(root, NewNode(), false, newHasher); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadDir_SHA256(b *testing.B) {
+	benchmarkReadDir(b, SHA256Factory)
+}
+
+func BenchmarkReadDir_XXH3(b *testing.B) {
+	benchmarkReadDir(b, XXH3Factory)
+}
+
+func TestReadDirWithProgress(t *testing.T) {
+	root, err := ioutil.TempDir("", "hashtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/deeper/c.txt", ".hidden/d.txt"} {
+		fname := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := NewNode()
+	for run := 0; run < 2; run++ {
+		var calls []int
+		err := ReadDirWithProgress(root, node, false, nil, func(filesHashed, totalFiles int, currentPath string) {
+			if totalFiles != 3 {
+				t.Fatalf("expected a total of 3 but got %d", totalFiles)
+			}
+
+			calls = append(calls, filesHashed)
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// unchanged files of the second run are reported as well
+		if len(calls) != 3 || calls[2] != 3 {
+			t.Fatalf("run %d: expected 3 progress calls but got %v", run, calls)
+		}
+
+		// the content of each file is its name
+		if node.Size != int64(len("a.txt")+len("sub/b.txt")+len("sub/deeper/c.txt")) {
+			t.Fatalf("run %d: unexpected total size %d", run, node.Size)
+		}
+	}
+}
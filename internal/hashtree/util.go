@@ -14,7 +14,32 @@
 
 package hashtree
 
-import "sort"
+import (
+	"path/filepath"
+	"sort"
+)
+
+// A ChangeKind describes how a file differs between two trees.
+type ChangeKind int
+
+const (
+	Added    ChangeKind = iota + 1 // Added files only exist in the new tree.
+	Modified                       // Modified files exist in both trees with different hashes.
+	Removed                        // Removed files only exist in the old tree.
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Removed:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
 
 // try executes the given func and updates the error,
 // but only if it has not been set yet.
@@ -29,10 +54,12 @@ func try(f func() error, err *error) {
 func PutTop(dst, src []File) []File {
 	tmp := map[string]File{} // that is expensive, we surely may want to use a slice with memcpy instead
 	for _, file := range dst {
+		file.Filename = filepath.ToSlash(file.Filename)
 		tmp[file.Filename] = file
 	}
 
 	for _, file := range src {
+		file.Filename = filepath.ToSlash(file.Filename)
 		tmp[file.Filename] = file
 	}
 
@@ -49,8 +76,10 @@ func PutTop(dst, src []File) []File {
 	return res
 }
 
-// FindFile returns the first entry index or -1 if not found. Expects that s is sorted ascending by name.
+// FindFile returns the first entry index or -1 if not found. Expects that s is sorted ascending by name. The
+// name may use the separator of the platform.
 func FindFile(s []File, name string) int {
+	name = filepath.ToSlash(name)
 	idx := sort.Search(len(s), func(i int) bool {
 		return s[i].Filename >= name
 	})
@@ -61,3 +90,111 @@ func FindFile(s []File, name string) int {
 
 	return -1
 }
+
+// Diff returns the file names of all regular files, which have been added, removed or modified between old and new.
+// Both slices must be sorted ascending by Filename, as returned by Node.Flatten. The result is sorted ascending.
+func Diff(old, new []File) []string {
+	var res []string
+	for _, file := range new {
+		if !file.Node.Mode.IsRegular() {
+			continue
+		}
+
+		idx := FindFile(old, file.Filename)
+		if idx == -1 || old[idx].Node.Hash != file.Node.Hash {
+			res = append(res, file.Filename)
+		}
+	}
+
+	for _, file := range old {
+		if !file.Node.Mode.IsRegular() {
+			continue
+		}
+
+		if FindFile(new, file.Filename) == -1 {
+			res = append(res, file.Filename)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// WalkChanged traverses both trees simultaneously in depth-first order and invokes fn for each regular file,
+// which has been added, removed or modified between old and new. In contrast to Diff, no change list is
+// allocated. The paths are slash separated like those of Diff and the hash is the one of the new file or of the
+// removed file. Either tree may be nil. The first error of fn stops the traversal and is returned. Note that subtrees
+// are never skipped by equal directory hashes, because these do not include the file names.
+func WalkChanged(old, new *Node, fn func(path string, kind ChangeKind, hash [32]byte) error) error {
+	return walkChanged(old, new, "", fn)
+}
+
+func walkChanged(old, new *Node, parent string, fn func(path string, kind ChangeKind, hash [32]byte) error) error {
+	switch {
+	case old == nil && new == nil:
+		return nil
+	case new == nil:
+		return walkAll(old, parent, Removed, fn)
+	case old == nil:
+		return walkAll(new, parent, Added, fn)
+	}
+
+	path := filepath.ToSlash(filepath.Join(parent, new.Name))
+	if old.Mode.IsRegular() && new.Mode.IsRegular() {
+		if old.Hash != new.Hash {
+			return fn(path, Modified, new.Hash)
+		}
+
+		return nil
+	}
+
+	// a file has become a directory or vice versa
+	if old.Mode.IsRegular() != new.Mode.IsRegular() {
+		if err := walkAll(old, parent, Removed, fn); err != nil {
+			return err
+		}
+
+		return walkAll(new, parent, Added, fn)
+	}
+
+	// children are sorted ascending by name, so merge them
+	i, j := 0, 0
+	for i < len(old.Children) || j < len(new.Children) {
+		var o, n *Node
+		switch {
+		case j >= len(new.Children) || (i < len(old.Children) && old.Children[i].Name < new.Children[j].Name):
+			o = old.Children[i]
+			i++
+		case i >= len(old.Children) || new.Children[j].Name < old.Children[i].Name:
+			n = new.Children[j]
+			j++
+		default:
+			o, n = old.Children[i], new.Children[j]
+			i++
+			j++
+		}
+
+		if err := walkChanged(o, n, path, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkAll invokes fn with the given kind for each regular file of the subtree.
+func walkAll(node *Node, parent string, kind ChangeKind, fn func(path string, kind ChangeKind, hash [32]byte) error) error {
+	path := filepath.ToSlash(filepath.Join(parent, node.Name))
+	if node.Mode.IsRegular() {
+		return fn(path, kind, node.Hash)
+	}
+
+	for _, child := range node.Children {
+		if err := walkAll(child, path, kind, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
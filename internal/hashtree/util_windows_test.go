@@ -0,0 +1,47 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashtree
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFindFileBackslashes(t *testing.T) {
+	css := &Node{Name: "css", Mode: os.ModeDir, Children: []*Node{{Name: "main.css"}}}
+	root := &Node{Mode: os.ModeDir, Children: []*Node{css, {Name: "index.html"}}}
+
+	flat := root.Flatten(`C:\src\static`)
+	for _, file := range flat {
+		if strings.Contains(file.Filename, `\`) {
+			t.Fatalf("expected slash separated names but got %s", file.Filename)
+		}
+	}
+
+	// e.g. collected by filepath.Walk
+	walked := []File{{Filename: `css\theme.css`, Node: &Node{Name: "theme.css"}}, {Filename: `css\main.css`, Node: &Node{Name: "main.css"}}}
+	merged := PutTop(flat, walked)
+
+	for _, name := range []string{`css\main.css`, "css/main.css", `css\theme.css`, "index.html"} {
+		if FindFile(merged, name) == -1 {
+			t.Fatalf("expected to find %s in %v", name, merged)
+		}
+	}
+
+	if len(merged) != len(flat)+1 {
+		t.Fatalf("expected css\\main.css to replace css/main.css but got %v", merged)
+	}
+}
@@ -15,24 +15,190 @@
 package http
 
 import (
+	"encoding/json"
+	"github.com/golangee/gotrino-make/internal/fsnotify"
 	"github.com/golangee/log"
 	"github.com/golangee/log/ecs"
 	"net/http"
 	"time"
 )
 
+// pollEvent is a single line of the pollVersion stream.
+type pollEvent struct {
+	Type    string `json:"type"` // Type is either heartbeat or change.
+	Version string `json:"version"`
+}
+
+// pollVersion streams newline separated json events. A heartbeat with the current version is sent every
+// pollHeartbeat, so that clients with short fetch timeouts keep waiting. A change event with the new version
+// is sent after the next build and ends the response, just like reaching the PollTimeout.
 func (s *Server) pollVersion(w http.ResponseWriter, r *http.Request) {
 	log.FromContext(r.Context()).Println(ecs.Msg("registered long poll"))
 
-	c := s.await()
-	select {
-	case version := <-c:
-		type Version struct {
-			Version string
+	timeout := s.PollTimeout
+	if timeout <= 0 {
+		timeout = DefaultPollTimeout
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	c, cancel := s.await()
+	defer cancel()
+
+	heartbeat := time.NewTicker(pollHeartbeat)
+	defer heartbeat.Stop()
+
+	expired := time.After(timeout)
+	for {
+		select {
+		case version := <-c:
+			log.FromContext(r.Context()).Println(ecs.Msg("returning " + version))
+			writeEvent(w, r, pollEvent{Type: "change", Version: version})
+			return
+		case <-heartbeat.C:
+			writeEvent(w, r, pollEvent{Type: "heartbeat", Version: s.currentVersion()})
+		case <-expired:
+			return
+		case <-r.Context().Done():
+			// the deferred cancel unregisters the poll, so that a closed tab does not leak its channel
+			log.FromContext(r.Context()).Println(ecs.Msg("long poll client disconnected"))
+			return
 		}
-		log.FromContext(r.Context()).Println(ecs.Msg("returning " + version))
-		writeJson(w, r, Version{Version: version})
-	case _ = <-time.After(50 * time.Second):
-		w.WriteHeader(http.StatusResetContent)
 	}
 }
+
+// writeEvent writes the event as a single line of json and flushes it to the client.
+func writeEvent(w http.ResponseWriter, r *http.Request, event pollEvent) {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		log.FromContext(r.Context()).Println(ecs.Msg("failed to marshal poll event"), ecs.ErrMsg(err))
+		return
+	}
+
+	if _, err := w.Write(append(buf, '\n')); err != nil {
+		log.FromContext(r.Context()).Println(ecs.Msg("failed to write poll event"), ecs.ErrMsg(err))
+		return
+	}
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *Server) watcherStats(w http.ResponseWriter, r *http.Request) {
+	type Stats struct {
+		WatchedDirs       int     `json:"watchedDirs"`
+		MaxInotifyWatches int     `json:"maxInotifyWatches"`
+		SaturationPct     float64 `json:"saturationPct"`
+	}
+
+	if s.watcher == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	max, err := fsnotify.MaxUserWatches()
+	if err != nil {
+		log.FromContext(r.Context()).Println(ecs.Msg("unable to read max user watches"), ecs.ErrMsg(err))
+	}
+
+	stats := Stats{
+		WatchedDirs:       s.watcher.WatchedCount(),
+		MaxInotifyWatches: max,
+	}
+
+	stats.SaturationPct = fsnotify.Saturation(stats.WatchedDirs, stats.MaxInotifyWatches)
+
+	writeJson(w, r, stats)
+}
+
+// buildProgress returns the hashing state of the running build, e.g. to show "hashing N/M files" in the browser.
+func (s *Server) buildProgress(w http.ResponseWriter, r *http.Request) {
+	type Progress struct {
+		Hashed int    `json:"hashed"`
+		Total  int    `json:"total"`
+		Path   string `json:"path"`
+	}
+
+	if s.progress == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var progress Progress
+	progress.Hashed, progress.Total, progress.Path = s.progress.HashProgress()
+
+	writeJson(w, r, progress)
+}
+
+// buildStats writes the summary of all builds.
+func (s *Server) buildStats(w http.ResponseWriter, r *http.Request) {
+	if s.stats == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeJson(w, r, s.stats())
+}
+
+func (s *Server) buildReset(w http.ResponseWriter, r *http.Request) {
+	if s.resetter == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.resetter.Reset()
+	log.FromContext(r.Context()).Println(ecs.Msg("build state has been reset"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) buildForce(w http.ResponseWriter, r *http.Request) {
+	if s.resetter == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := s.resetter.ForceRebuild(); err != nil {
+		log.FromContext(r.Context()).Println(ecs.Msg("forced rebuild failed"), ecs.ErrMsg(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.FromContext(r.Context()).Println(ecs.Msg("forced rebuild finished"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// profileStart requests the pages to call the startProfile function of a profiling app.wasm. The server cannot
+// call into the browser, so the pages poll the profileState, see BuildInfo.WasmProfile.
+func (s *Server) profileStart(w http.ResponseWriter, r *http.Request) {
+	s.setProfiling(true)
+	log.FromContext(r.Context()).Println(ecs.Msg("wasm profiling requested"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// profileStop requests the pages to call the stopProfile function, which returns the recorded profile.
+func (s *Server) profileStop(w http.ResponseWriter, r *http.Request) {
+	s.setProfiling(false)
+	log.FromContext(r.Context()).Println(ecs.Msg("wasm profiling stopped"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) profileState(w http.ResponseWriter, r *http.Request) {
+	type State struct {
+		Running bool `json:"running"`
+	}
+
+	s.profileLock.Lock()
+	state := State{Running: s.profiling}
+	s.profileLock.Unlock()
+
+	writeJson(w, r, state)
+}
+
+func (s *Server) setProfiling(running bool) {
+	s.profileLock.Lock()
+	defer s.profileLock.Unlock()
+
+	s.profiling = running
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// unavailableResponse is written to connections, which exceed the limit of a limitListener.
+const unavailableResponse = "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
+
+// maxDrainBytes is the maximum amount of bytes to read from a rejected connection.
+const maxDrainBytes = 64 * 1024
+
+// limitListener accepts at most cap(sem) concurrent connections. In contrast to netutil.LimitListener, exceeding
+// connections are not queued but rejected immediately, so that a load test cannot exhaust the file descriptors.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+	tls bool // tls is true, if the connections are wrapped by tls later, so that no plain 503 can be written.
+}
+
+// newLimitListener wraps the given listener. See limitListener.
+func newLimitListener(l net.Listener, maxConns int, tls bool) net.Listener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, maxConns),
+		tls:      tls,
+	}
+}
+
+// Accept returns the next connection below the limit and rejects all other connections in the meantime.
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+		default:
+			go l.reject(c)
+		}
+	}
+}
+
+// reject answers a plain http connection with 503 and closes it. The response is written after the request has
+// arrived, because clients discard responses on idle connections. The request is drained before closing,
+// otherwise unread data causes a connection reset, before the client has read the response.
+func (l *limitListener) reject(c net.Conn) {
+	defer c.Close()
+
+	if l.tls {
+		return
+	}
+
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1024)); err != nil {
+		return
+	}
+
+	if _, err := c.Write([]byte(unavailableResponse)); err != nil {
+		return
+	}
+
+	if tcp, ok := c.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(c, maxDrainBytes))
+}
+
+// limitConn releases its slot of the limitListener, when closed.
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+
+	return err
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered := make(chan struct{})
+	done := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-done
+	})}
+
+	go srv.Serve(newLimitListener(l, 1, false))
+	defer srv.Close()
+
+	url := "http://" + l.Addr().String()
+	go func() {
+		res, err := http.Get(url)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	<-entered
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+	close(done)
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 but got %d", res.StatusCode)
+	}
+}
@@ -15,12 +15,121 @@
 package http
 
 import (
+	"encoding/hex"
+	"github.com/golangee/gotrino-make/internal/metrics"
 	"github.com/golangee/log"
 	"github.com/golangee/log/ecs"
 	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
+// RegisterMIMETypes ensures the correct content types for files, which are not known on all platforms.
+// Without application/wasm, browsers refuse WebAssembly.instantiateStreaming.
+func RegisterMIMETypes() {
+	_ = mime.AddExtensionType(".wasm", "application/wasm")
+}
+
+// serveGzipped rewrites the request to <path>.gz, if the client accepts gzip and a pre-compressed file exists
+// next to the requested one in dir. The content type of the original file is kept.
+func serveGzipped(dir string, w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || strings.HasSuffix(r.URL.Path, ".gz") {
+		return
+	}
+
+	fname := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	if stat, err := os.Stat(fname); err != nil || !stat.Mode().IsRegular() {
+		return
+	}
+
+	if _, err := os.Stat(fname + ".gz"); err != nil {
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(fname))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	r.URL.Path += ".gz"
+}
+
+// setPrecompressedHeaders declares the content type of pre-compressed wasm files, because the extension
+// based detection would only see the .gz suffix.
+func setPrecompressedHeaders(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, ".wasm.gz") {
+		w.Header().Set("Content-Type", "application/wasm")
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+}
+
+// setETag declares the content hash of the requested file as its ETag. In contrast to the ModTime based
+// Last-Modified header, it does not change, if a build rewrites a file with the same content. The file server
+// answers If-None-Match requests with 304 accordingly.
+func setETag(hashes HashProvider, w http.ResponseWriter, r *http.Request) {
+	if hashes == nil {
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+
+	if hash, ok := hashes(name); ok {
+		w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:])+`"`)
+	}
+}
+
+// FallbackFilenames are the pre-rendered pages in the served directory, which are tried in order, if the
+// web assembly module is unavailable.
+var FallbackFilenames = []string{"build-error.html", "400.html"}
+
+// wasmFallbackMiddleware answers requests to the root of basePath with the first existing fallback page of dir,
+// as long as the wasm module at wasmPath is missing or empty. Without a fallback page, the request passes.
+func wasmFallbackMiddleware(dir, basePath, wasmPath string) func(http.Handler) http.Handler {
+	root := strings.TrimSuffix(basePath, "/") + "/"
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || (r.URL.Path != root && r.URL.Path+"/" != root) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			wasmFile := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+wasmPath)))
+			if stat, err := os.Stat(wasmFile); err == nil && stat.Size() > 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, name := range FallbackFilenames {
+				buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					continue
+				}
+
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Header().Set("Cache-Control", "no-store")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write(buf)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // newRouter creates a router and connects the endpoints with the given server and its methods.
 func (s *Server) newRouter(fileServerDir string) *httprouter.Router {
 	logMe := func(p string) string {
@@ -28,14 +137,43 @@ func (s *Server) newRouter(fileServerDir string) *httprouter.Router {
 		return p
 	}
 
+	RegisterMIMETypes()
+
 	router := httprouter.New()
 	router.HandlerFunc(http.MethodGet, logMe("/blub"), func(writer http.ResponseWriter, request *http.Request) {
 		s.logger.Println(ecs.Msg("hello world"))
 	})
 	router.HandlerFunc(http.MethodGet, logMe("/api/v1/poll/version"), s.pollVersion)
+	router.HandlerFunc(http.MethodGet, logMe("/api/v1/watcher/stats"), s.watcherStats)
+	router.HandlerFunc(http.MethodGet, logMe("/api/v1/build/progress"), s.buildProgress)
+	router.HandlerFunc(http.MethodGet, logMe("/api/v1/build/stats"), s.buildStats)
+	router.HandlerFunc(http.MethodPost, logMe("/api/v1/build/reset"), s.buildReset)
+	router.HandlerFunc(http.MethodPost, logMe("/api/v1/build/force"), s.buildForce)
+	router.HandlerFunc(http.MethodPost, logMe("/api/v1/profile/start"), s.profileStart)
+	router.HandlerFunc(http.MethodPost, logMe("/api/v1/profile/stop"), s.profileStop)
+	router.HandlerFunc(http.MethodGet, logMe("/api/v1/profile/state"), s.profileState)
+
+	if metrics.Enabled() {
+		router.Handler(http.MethodGet, logMe("/metrics"), metrics.Handler())
+	}
 
 	if fileServerDir != "" {
-		router.NotFound = http.FileServer(http.Dir(logMe(fileServerDir)))
+		fileServer := http.FileServer(http.Dir(logMe(fileServerDir)))
+		var handler http.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			s.pause.RLock()
+			defer s.pause.RUnlock()
+
+			serveGzipped(fileServerDir, writer, request)
+			setPrecompressedHeaders(writer, request)
+			setETag(s.hashes, writer, request)
+			fileServer.ServeHTTP(writer, request)
+		})
+
+		if prefix := strings.TrimSuffix(s.basePath, "/"); prefix != "" {
+			handler = http.StripPrefix(logMe(prefix), handler)
+		}
+
+		router.NotFound = handler
 	}
 
 	return router
@@ -0,0 +1,431 @@
+package http
+
+import (
+	"context"
+	"github.com/golangee/log"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWasmContentType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"app.wasm", "app.wasm.gz"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("\x00asm"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := NewServer(log.NewLogger(), "localhost", 0, dir).newRouter(dir)
+
+	tests := []struct {
+		path     string
+		encoding string
+	}{
+		{path: "/app.wasm"},
+		{path: "/app.wasm.gz", encoding: "gzip"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200 but got %d", tt.path, rec.Code)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != "application/wasm" {
+			t.Fatalf("%s: expected application/wasm but got %s", tt.path, got)
+		}
+
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Fatalf("%s: expected encoding '%s' but got '%s'", tt.path, tt.encoding, got)
+		}
+	}
+}
+
+func TestServeGzipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"main.css": "body{}", "main.css.gz": "compressed"}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := NewServer(log.NewLogger(), "localhost", 0, dir).newRouter(dir)
+
+	req := httptest.NewRequest(http.MethodGet, "/main.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.String() != "compressed" {
+		t.Fatalf("expected the gzip file but got %s: %s", rec.Header(), rec.Body.String())
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "text/css; charset=utf-8" {
+		t.Fatalf("expected the content type of the original file but got %s", got)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/main.css", nil))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "body{}" {
+		t.Fatalf("expected the plain file but got %s: %s", rec.Header(), rec.Body.String())
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "wasm_exec.js"), []byte("bridge"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(log.NewLogger(), "localhost", 0, dir)
+	srv.SetBasePath("/app/")
+	router := srv.newRouter(dir)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app/wasm_exec.js", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "bridge" {
+		t.Fatalf("expected the file below the base path but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/wasm_exec.js", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 outside of the base path but got %d", rec.Code)
+	}
+}
+
+func TestETag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(log.NewLogger(), "localhost", 0, dir)
+	srv.SetHashProvider(func(path string) ([32]byte, bool) {
+		return [32]byte{1}, path == "/index.html"
+	})
+
+	router := srv.newRouter(dir)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an etag but got %d: %s", rec.Code, rec.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 but got %d", rec.Code)
+	}
+}
+
+func TestPollVersion(t *testing.T) {
+	srv := NewServer(log.NewLogger(), "localhost", 0, "")
+	srv.PollTimeout = 50 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	srv.pollVersion(rec, httptest.NewRequest(http.MethodGet, "/api/v1/poll/version", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatalf("expected an empty stream after the timeout but got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	srv = NewServer(log.NewLogger(), "localhost", 0, "")
+	srv.PollTimeout = time.Second
+	go func() {
+		for countAwaiting(srv) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		srv.NotifyChanged("v2")
+	}()
+
+	rec = httptest.NewRecorder()
+	srv.pollVersion(rec, httptest.NewRequest(http.MethodGet, "/api/v1/poll/version", nil))
+	if got := rec.Body.String(); got != `{"type":"change","version":"v2"}`+"\n" {
+		t.Fatalf("expected a change event but got %s", got)
+	}
+}
+
+func TestPollVersionDisconnect(t *testing.T) {
+	srv := NewServer(log.NewLogger(), "localhost", 0, "")
+	srv.PollTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for countAwaiting(srv) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/poll/version", nil).WithContext(ctx)
+		srv.pollVersion(httptest.NewRecorder(), req)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the poll to return after the client disconnected")
+	}
+
+	if n := countAwaiting(srv); n != 0 {
+		t.Fatalf("expected the disconnected poll to be removed but %d remain", n)
+	}
+}
+
+// countAwaiting returns the amount of registered polls.
+func countAwaiting(srv *Server) int {
+	n := 0
+	srv.awaiting.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+
+	return n
+}
+
+func TestNotifyChangedFanOut(t *testing.T) {
+	srv := NewServer(log.NewLogger(), "localhost", 0, "")
+
+	var cancels []func()
+	var channels []chan string
+	for i := 0; i < 100; i++ {
+		c, cancel := srv.await()
+		channels = append(channels, c)
+		cancels = append(cancels, cancel)
+	}
+
+	srv.NotifyChanged("v1")
+	for i, c := range channels {
+		select {
+		case v := <-c:
+			if v != "v1" {
+				t.Fatalf("subscriber %d: expected v1 but got %s", i, v)
+			}
+		default:
+			t.Fatalf("subscriber %d has not been notified", i)
+		}
+	}
+
+	// a subscriber, which does not consume, is dropped instead of blocking
+	srv.NotifyChanged("v2")
+	srv.NotifyChanged("v3")
+	if n := countAwaiting(srv); n != 0 {
+		t.Fatalf("expected all slow subscribers to be dropped but %d remain", n)
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := corsMiddleware([]string{"http://localhost:6006"}, []string{"GET", "POST"})(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+	req.Header.Set("Origin", "http://localhost:6006")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:6006" {
+		t.Fatalf("expected the origin to be allowed but got '%s'", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/index.html", nil)
+	req.Header.Set("Origin", "http://example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected the origin to be rejected but got '%s'", got)
+	}
+
+	req = httptest.NewRequest(http.MethodOptions, "/api/v1/build/force", nil)
+	req.Header.Set("Origin", "http://localhost:6006")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for a preflight but got %d", rec.Code)
+	}
+
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Fatalf("unexpected allowed methods '%s'", got)
+	}
+}
+
+func TestProfileState(t *testing.T) {
+	router := NewServer(log.NewLogger(), "localhost", 0, "").newRouter("")
+
+	state := func() string {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/profile/state", nil))
+
+		return rec.Body.String()
+	}
+
+	if got := state(); got != `{"running":false}` {
+		t.Fatalf("expected no running profile but got %s", got)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{path: "/api/v1/profile/start", want: `{"running":true}`},
+		{path: "/api/v1/profile/stop", want: `{"running":false}`},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s: expected status 204 but got %d", tt.path, rec.Code)
+		}
+
+		if got := state(); got != tt.want {
+			t.Fatalf("%s: expected %s but got %s", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var fromContext string
+	handler := requestIDMiddleware(log.NewLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromContext = RequestID(r.Context())
+	}))
+
+	ids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		id := rec.Header().Get("X-Request-ID")
+		if len(id) != 32 || id[12] != '4' {
+			t.Fatalf("expected a compact uuid v4 but got '%s'", id)
+		}
+
+		if fromContext != id {
+			t.Fatalf("expected the context to contain %s but got %s", id, fromContext)
+		}
+
+		ids[id] = true
+	}
+
+	if len(ids) != 2 {
+		t.Fatalf("expected unique ids but got %v", ids)
+	}
+}
+
+func TestWasmFallbackMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := wasmFallbackMiddleware(dir, "/app", "app.wasm")(next)
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return rec
+	}
+
+	if rec := serve("/app/"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 without a fallback page but got %d", rec.Code)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "build-error.html"), []byte("broken"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.wasm"), nil, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/app", "/app/"} {
+		rec := serve(path)
+		if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "broken" {
+			t.Fatalf("%s: expected the fallback page but got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := serve("/app/index.css"); rec.Code != http.StatusOK {
+		t.Fatalf("expected other files to pass but got %d", rec.Code)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.wasm"), []byte("\x00asm"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve("/app/"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a wasm module but got %d", rec.Code)
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	srv := NewServer(log.NewLogger(), "localhost", 0, "")
+	rec := httptest.NewRecorder()
+	srv.newRouter("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/build/stats", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without stats but got %d", rec.Code)
+	}
+
+	srv.SetStatsProvider(func() BuildStats {
+		return BuildStats{TotalBuilds: 3, Succeeded: 2, Failed: 1, Skipped: 4, AvgDurationMs: 20, MinDurationMs: 10, MaxDurationMs: 30}
+	})
+	rec = httptest.NewRecorder()
+	srv.newRouter("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/build/stats", nil))
+
+	want := `{"totalBuilds":3,"succeeded":2,"failed":1,"skipped":4,"avgDurationMs":20,"minDurationMs":10,"maxDurationMs":30}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Fatalf("expected %s but got %s", want, got)
+	}
+}
@@ -16,10 +16,18 @@ package http
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"github.com/golangee/gotrino-make/internal/metrics"
 	"github.com/golangee/log"
 	"github.com/golangee/log/ecs"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,41 +36,187 @@ type Server struct {
 	host     string
 	port     int
 	httpSrv  *http.Server
+	redirect *http.Server // redirect is the http to https redirect server for autocert.
+	tls      TLSOptions
+	basePath string // basePath is the URL prefix of all served files.
+	hashes   HashProvider
 	dir      string
 	logger   log.Logger
-	awaiting chan chan string
+	awaiting sync.Map // awaiting contains the registered chan string of each version poll as key.
+	watcher  WatcherStats
+	resetter Resetter
+	progress BuildProgress
+	stats    StatsProvider
+	pause    sync.RWMutex // pause blocks the file server while the build output is replaced.
+	// PollTimeout is the maximum duration of a version poll, before the client must poll again. It should be
+	// shorter than the write timeout of 60 seconds. If zero, DefaultPollTimeout is used.
+	PollTimeout time.Duration
+	version     string     // version is the version of the last build.
+	versionLock sync.Mutex // versionLock guards version.
+	profiling   bool       // profiling is true between a profile start and stop request.
+	profileLock sync.Mutex // profileLock guards profiling.
+	// MaxConns limits the amount of concurrent connections. Exceeding connections receive a
+	// 503 Service Unavailable. 0 means unlimited. It must be set before Run.
+	MaxConns int
+	// CORSOrigins are the origins, which are allowed to fetch from the server, or * for any origin. If empty,
+	// no CORS headers are sent. It must be set before Run.
+	CORSOrigins []string
+	// CORSMethods are the methods allowed for cross-origin requests.
+	CORSMethods []string
+	// WasmPath is the slash separated path of the web assembly module, relative to the served directory. If it
+	// is missing or empty, requests to the root are answered with a fallback page, see FallbackFilenames. If
+	// empty, no fallback is served. It must be set before Run.
+	WasmPath string
 }
 
+// requestIDHeader is the response header, which contains the id of each request.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the request id.
+type requestIDKey struct{}
+
+// TLSOptions configures https. Without any of them, plain http is served.
+type TLSOptions struct {
+	CertFile string // CertFile and KeyFile are used for a manually managed certificate.
+	KeyFile  string
+	// AutocertDomain requests certificates from Let's Encrypt automatically. The server listens on 443 and
+	// redirects http from port 80.
+	AutocertDomain   string
+	AutocertCacheDir string // AutocertCacheDir keeps the certificates between restarts.
+}
+
+// WatcherStats provides debugging information about the file watcher.
+type WatcherStats interface {
+	// WatchedCount returns the amount of watched directories.
+	WatchedCount() int
+}
+
+// BuildProgress provides the state of the running build.
+type BuildProgress interface {
+	// HashProgress returns how many source files have been hashed. The total is 0, if nothing is hashed.
+	HashProgress() (hashed, total int, path string)
+}
+
+// BuildStats summarizes the amount, results and durations of all builds. Builds, which have been skipped due to
+// unchanged sources, are only counted as Skipped and do not affect the durations.
+type BuildStats struct {
+	TotalBuilds   int   `json:"totalBuilds"`
+	Succeeded     int   `json:"succeeded"`
+	Failed        int   `json:"failed"`
+	Skipped       int   `json:"skipped"`
+	AvgDurationMs int64 `json:"avgDurationMs"`
+	MinDurationMs int64 `json:"minDurationMs"`
+	MaxDurationMs int64 `json:"maxDurationMs"`
+}
+
+// A StatsProvider returns the summary of all builds.
+type StatsProvider func() BuildStats
+
+// Resetter discards cached build state.
+type Resetter interface {
+	// Reset forces a full rebuild on the next build.
+	Reset()
+	// ForceRebuild builds now, even if no source has been changed.
+	ForceRebuild() error
+}
+
+const (
+	// DefaultPollTimeout is the default of Server.PollTimeout.
+	DefaultPollTimeout = 50 * time.Second
+	// pollHeartbeat is the interval of heartbeat events while polling the version.
+	pollHeartbeat = 10 * time.Second
+)
+
+// A HashProvider returns the content hash of a served file, e.g. /index.html, which is used as its ETag.
+type HashProvider func(path string) ([32]byte, bool)
+
 // NewServer prepares a new Server instance.
 func NewServer(logger log.Logger, host string, port int, dir string) *Server {
 	s := &Server{
-		host:     host,
-		port:     port,
-		logger:   logger,
-		dir:      dir,
-		awaiting: make(chan chan string, 10_000), // TODO await will stop working when capacity reached
+		host:   host,
+		port:   port,
+		logger: logger,
+		dir:    dir,
 	}
 
 	return s
 }
 
+// NotifyChanged sends the new version to all awaiting polls. It never blocks: a poll, which has not yet
+// consumed a former notification, is too slow and is dropped.
 func (s *Server) NotifyChanged(version string) {
-	// drain entire awaiting channels
-	// TODO if clients re-connect to fast we have an endless loop here
-	for {
+	s.versionLock.Lock()
+	s.version = version
+	s.versionLock.Unlock()
+
+	s.awaiting.Range(func(key, value interface{}) bool {
 		select {
-		case c := <-s.awaiting:
-			c <- version
+		case key.(chan string) <- version:
 		default:
-			return
+			s.awaiting.Delete(key)
 		}
-	}
+
+		return true
+	})
+}
+
+// currentVersion returns the version of the last build.
+func (s *Server) currentVersion() string {
+	s.versionLock.Lock()
+	defer s.versionLock.Unlock()
+
+	return s.version
+}
+
+// Pause blocks serving static files, until resume is called.
+func (s *Server) Pause() (resume func()) {
+	s.pause.Lock()
+	return s.pause.Unlock
+}
+
+// SetWatcher enables the watcher stats endpoint.
+func (s *Server) SetWatcher(w WatcherStats) {
+	s.watcher = w
+}
+
+// SetBuildProgress enables the build progress endpoint.
+func (s *Server) SetBuildProgress(p BuildProgress) {
+	s.progress = p
+}
+
+// SetStatsProvider enables the build stats endpoint.
+func (s *Server) SetStatsProvider(p StatsProvider) {
+	s.stats = p
+}
+
+// SetResetter enables the build reset endpoint.
+func (s *Server) SetResetter(r Resetter) {
+	s.resetter = r
 }
 
-func (s *Server) await() chan string {
-	c := make(chan string, 1)
-	s.awaiting <- c
-	return c
+// SetHashProvider enables content based ETags for static files.
+func (s *Server) SetHashProvider(h HashProvider) {
+	s.hashes = h
+}
+
+// SetBasePath serves all files below the given URL prefix, e.g. /app/. It must be called before Run.
+func (s *Server) SetBasePath(basePath string) {
+	s.basePath = basePath
+}
+
+// SetTLS enables https. It must be called before Run.
+func (s *Server) SetTLS(opts TLSOptions) {
+	s.tls = opts
+}
+
+// await registers a channel, which receives the next version. Cancel must be called, when done.
+func (s *Server) await() (c chan string, cancel func()) {
+	c = make(chan string, 1)
+	s.awaiting.Store(c, struct{}{})
+
+	return c, func() {
+		s.awaiting.Delete(c)
+	}
 }
 
 // Run launches the server
@@ -73,11 +227,30 @@ func (s *Server) Run() error {
 		Addr:         fmt.Sprintf("%s:%d", s.host, s.port),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 60 * time.Second,
-		Handler:      router,
 	}
 
-	s.logger.Println(ecs.Msg("starting"), ecs.ServerAddress(s.host), ecs.ServerPort(s.port))
-	err := s.httpSrv.ListenAndServe()
+	var handler http.Handler = router
+	if s.WasmPath != "" && s.dir != "" {
+		handler = wasmFallbackMiddleware(s.dir, s.basePath, s.WasmPath)(handler)
+	}
+
+	if len(s.CORSOrigins) > 0 {
+		handler = corsMiddleware(s.CORSOrigins, s.CORSMethods)(handler)
+	}
+
+	s.httpSrv.Handler = metrics.Middleware(requestIDMiddleware(s.logger)(handler))
+
+	var err error
+	switch {
+	case s.tls.AutocertDomain != "":
+		err = s.runAutocert()
+	case s.tls.CertFile != "" || s.tls.KeyFile != "":
+		s.logger.Println(ecs.Msg("starting tls"), ecs.ServerAddress(s.host), ecs.ServerPort(s.port))
+		err = s.serve(s.httpSrv, s.tls.CertFile, s.tls.KeyFile, true)
+	default:
+		s.logger.Println(ecs.Msg("starting"), ecs.ServerAddress(s.host), ecs.ServerPort(s.port))
+		err = s.serve(s.httpSrv, "", "", false)
+	}
 
 	if err == http.ErrServerClosed {
 		s.logger.Println(ecs.Msg("stopped"))
@@ -87,6 +260,139 @@ func (s *Server) Run() error {
 	return err
 }
 
+// runAutocert serves https on port 443 using Let's Encrypt certificates and redirects http from port 80.
+func (s *Server) runAutocert() error {
+	if err := os.MkdirAll(s.tls.AutocertCacheDir, 0700); err != nil {
+		return fmt.Errorf("unable to create autocert cache dir: %w", err)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(s.tls.AutocertDomain),
+		Cache:      autocert.DirCache(s.tls.AutocertCacheDir),
+	}
+
+	s.httpSrv.Addr = fmt.Sprintf("%s:%d", s.host, 443)
+	s.httpSrv.TLSConfig = m.TLSConfig()
+
+	s.redirect = &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", s.host, 80),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler:      m.HTTPHandler(nil), // answers acme challenges and redirects everything else to https
+	}
+
+	go func() {
+		if err := s.redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Println(ecs.Msg("failed to serve http redirect"), ecs.ErrMsg(err))
+		}
+	}()
+
+	s.logger.Println(ecs.Msg("starting autocert tls for "+s.tls.AutocertDomain), ecs.ServerAddress(s.host), ecs.ServerPort(443))
+
+	return s.serve(s.httpSrv, "", "", true)
+}
+
+// requestIDMiddleware tags each request with a new X-Request-ID, which is returned as response header and
+// logged by the logger of the request context, so that a browser request can be matched with the server log.
+func requestIDMiddleware(logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := newRequestID()
+			if err != nil {
+				logger.Println(ecs.Msg("unable to create request id"), ecs.ErrMsg(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set(requestIDHeader, id)
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = log.WithLogger(ctx, log.WithFields(logger, log.V("http.request.id", id)))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestID returns the X-Request-ID of the request context or the empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random UUID v4 as 32 hex characters without dashes.
+func newRequestID() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", err
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40 // version 4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80 // variant 10
+
+	return hex.EncodeToString(uuid[:]), nil
+}
+
+// corsMiddleware adds the CORS headers for each request from one of the given origins and answers preflight
+// requests itself. An origin of * allows any origin.
+func corsMiddleware(origins, methods []string) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	allowMethods := strings.Join(methods, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || (!allowed["*"] && !allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if allowMethods != "" {
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// serve listens on the address of the given server and applies the MaxConns limit, if any.
+func (s *Server) serve(srv *http.Server, certFile, keyFile string, tls bool) error {
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	if s.MaxConns > 0 {
+		l = newLimitListener(l, s.MaxConns, tls)
+	}
+
+	if tls {
+		return srv.ServeTLS(l, certFile, keyFile)
+	}
+
+	return srv.Serve(l)
+}
+
 // Stop signals the server to halt gracefully.
 func (s *Server) Stop() {
 	// normal if never run
@@ -98,4 +404,10 @@ func (s *Server) Stop() {
 	if err := s.httpSrv.Shutdown(ctx); err != nil {
 		s.logger.Println(ecs.Msg("failed to shutdown"), ecs.ErrMsg(err))
 	}
+
+	if s.redirect != nil {
+		if err := s.redirect.Shutdown(ctx); err != nil {
+			s.logger.Println(ecs.Msg("failed to shutdown redirect"), ecs.ErrMsg(err))
+		}
+	}
 }
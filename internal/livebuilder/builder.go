@@ -20,9 +20,13 @@ import (
 	"fmt"
 	"github.com/golangee/gotrino-make/internal/builder"
 	"github.com/golangee/gotrino-make/internal/fsnotify"
+	"github.com/golangee/gotrino-make/internal/git"
+	"github.com/golangee/gotrino-make/internal/metrics"
+	"github.com/golangee/gotrino-make/internal/notify"
 	"github.com/golangee/log"
 	"github.com/golangee/log/ecs"
 	"sync"
+	"time"
 )
 
 // Builder provides an automatic live builder which rebuilds an idiomatic golangee wasm project any time it
@@ -31,10 +35,21 @@ type Builder struct {
 	logger         log.Logger
 	srcDir, dstDir string
 	buildLock      sync.Mutex
+	notifyLock     sync.Mutex // notifyLock keeps the notifications in the order of their builds.
 	watcher        *fsnotify.Watcher
 	buildFinished  func(hash string)
 	opts           builder.Options
 	project        *builder.Project
+	hashLock       sync.RWMutex
+	hashes         map[string][32]byte // hashes contains the output file hashes of the last build.
+	progressLock   sync.Mutex
+	progress       hashProgress // progress is the state of the running build.
+}
+
+// hashProgress contains the last notification of builder.Options.HashProgress.
+type hashProgress struct {
+	hashed, total int
+	path          string
 }
 
 func NewBuilder(dstDir, srcDir string, buildFinished func(hash string), opts builder.Options) (*Builder, error) {
@@ -45,6 +60,8 @@ func NewBuilder(dstDir, srcDir string, buildFinished func(hash string), opts bui
 		opts:          opts,
 	}
 
+	b.opts.HashProgress = b.setHashProgress
+
 	prj, err := builder.NewProject(dstDir, srcDir)
 	if err != nil {
 		return nil, fmt.Errorf("unable to setup project builder: %w", err)
@@ -53,7 +70,7 @@ func NewBuilder(dstDir, srcDir string, buildFinished func(hash string), opts bui
 	b.project = prj
 	b.logger = log.NewLogger(ecs.Log("livebuilder"))
 
-	w, err := fsnotify.NewWatcher(srcDir, func() {
+	w, err := fsnotify.NewWatcher(srcDir, opts.WatchDebounce, func() {
 		if err := b.Build(); err != nil {
 			b.logger.Println("failed to build", err)
 		}
@@ -70,30 +87,181 @@ func NewBuilder(dstDir, srcDir string, buildFinished func(hash string), opts bui
 	return b, nil
 }
 
-// Build triggers a build now
+// Build triggers a build now and notifies about its result, after the build lock has been released.
 func (b *Builder) Build() error {
 	b.buildLock.Lock()
-	defer b.buildLock.Unlock()
+	hash, duration, err := b.build()
+
+	// hand over to the notify lock, so that a slow webhook does not delay the next build but the
+	// notifications are still sent in the order of their builds
+	b.notifyLock.Lock()
+	defer b.notifyLock.Unlock()
+	b.buildLock.Unlock()
+
+	b.notify(hash, err, duration)
+
+	return err
+}
 
+// build must be called with the build lock held.
+func (b *Builder) build() ([32]byte, time.Duration, error) {
 	if b.opts.Debug {
 		b.logger.Println("building started...")
 	}
 
+	start := time.Now()
 	hash, err := b.project.Build(b.opts)
 	if err != nil {
 		var buildErr builder.CompileErr
 		if !errors.As(err, &buildErr) {
-			return fmt.Errorf("unable to build wasm project: %w", err)
+			metrics.ObserveBuild(metrics.StatusError, time.Since(start))
+
+			return hash, time.Since(start), fmt.Errorf("unable to build wasm project: %w", err)
+		}
+
+		metrics.ObserveBuild(metrics.StatusCompileError, time.Since(start))
+
+		if b.opts.CleanOnError {
+			if err := b.project.CleanGenerated(); err != nil {
+				b.logger.Println(ecs.Msg("unable to clean generated files"), ecs.ErrMsg(err))
+			}
+		}
+	}
+
+	if err == nil {
+		metrics.ObserveBuild(metrics.StatusSuccess, time.Since(start))
+	}
+
+	duration := time.Since(start)
+
+	if hashes, hashErr := b.project.OutputHashes(); hashErr != nil {
+		b.logger.Println(ecs.Msg("unable to hash output"), ecs.ErrMsg(hashErr))
+	} else {
+		b.hashLock.Lock()
+		b.hashes = hashes
+		b.hashLock.Unlock()
+	}
+
+	// sibling modules from replace directives may have been added, so watch them as well
+	if b.watcher != nil {
+		for _, dir := range b.project.ReplaceDirs() {
+			if err := b.watcher.AddRoot(dir); err != nil {
+				b.logger.Println(ecs.Msg("unable to watch replaced module "+dir), ecs.ErrMsg(err))
+			}
 		}
 	}
 
+	b.setHashProgress(0, 0, "")
+
 	if b.buildFinished != nil {
 		b.buildFinished(hex.EncodeToString(hash[:]))
 	}
 
+	return hash, duration, err
+}
+
+// notify shows the result of a build as a desktop notification and posts it to the webhook, if configured.
+// Failures are only logged.
+func (b *Builder) notify(hash [32]byte, buildErr error, duration time.Duration) {
+	if b.opts.DesktopNotify {
+		var err error
+		if buildErr != nil {
+			err = notify.NotifyFailure(fmt.Sprintf("build failed: %v", buildErr))
+		} else {
+			err = notify.NotifySuccess(fmt.Sprintf("build succeeded in %v", duration.Round(time.Millisecond)))
+		}
+
+		if err != nil {
+			b.logger.Println(ecs.Msg("unable to show desktop notification"), ecs.ErrMsg(err))
+		}
+	}
+
+	if b.opts.NotifyURL != "" {
+		result := notify.BuildResult{
+			Hash:       hex.EncodeToString(hash[:]),
+			DurationMs: int64(duration / time.Millisecond),
+		}
+
+		if buildErr != nil {
+			msg := buildErr.Error()
+			result.Error = &msg
+		}
+
+		// a project without git has no commit
+		result.Commit, _ = git.Head(b.srcDir)
+
+		if err := notify.PostBuildResult(b.opts.NotifyURL, b.opts.NotifySecret, result); err != nil {
+			b.logger.Println(ecs.Msg("unable to notify webhook"), ecs.ErrMsg(err))
+		}
+	}
+}
+
+// setHashProgress is the builder.Options.HashProgress.
+func (b *Builder) setHashProgress(filesHashed, totalFiles int, currentPath string) {
+	b.progressLock.Lock()
+	defer b.progressLock.Unlock()
+
+	b.progress = hashProgress{hashed: filesHashed, total: totalFiles, path: currentPath}
+}
+
+// HashProgress returns how many source files of the current module have been hashed by the running build.
+// The total is 0, if no files are hashed right now.
+func (b *Builder) HashProgress() (hashed, total int, path string) {
+	b.progressLock.Lock()
+	defer b.progressLock.Unlock()
+
+	return b.progress.hashed, b.progress.total, b.progress.path
+}
+
+// Reset discards all cached build state, so that the next Build is a full rebuild.
+func (b *Builder) Reset() {
+	b.buildLock.Lock()
+	defer b.buildLock.Unlock()
+
+	b.project.Reset()
+}
+
+// ForceRebuild builds now and invokes the compiler, even if no source has been changed. Compile errors are
+// not returned, because they are delivered just like the errors of any other build.
+func (b *Builder) ForceRebuild() error {
+	b.buildLock.Lock()
+	b.project.ForceRebuild()
+	b.buildLock.Unlock()
+
+	err := b.Build()
+	var buildErr builder.CompileErr
+	if errors.As(err, &buildErr) {
+		return nil
+	}
+
 	return err
 }
 
+// FileHash returns the content hash of the given output file of the last build, e.g. /index.html.
+func (b *Builder) FileHash(path string) ([32]byte, bool) {
+	b.hashLock.RLock()
+	defer b.hashLock.RUnlock()
+
+	hash, ok := b.hashes[path]
+
+	return hash, ok
+}
+
+// Stats returns the summary of all builds since the Builder has been created.
+func (b *Builder) Stats() builder.BuildStats {
+	return b.project.Stats()
+}
+
+// WatchedCount returns the amount of directories which are currently watched for changes.
+func (b *Builder) WatchedCount() int {
+	return b.watcher.WatchedCount()
+}
+
 func (b *Builder) Close() error {
+	if err := b.project.Close(); err != nil {
+		_ = b.watcher.Close()
+		return err
+	}
+
 	return b.watcher.Close()
 }
@@ -0,0 +1,138 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics collects prometheus metrics about builds and served requests. The collection is disabled
+// by default and all observe functions are no-ops until Enable has been called.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Build status label values.
+const (
+	StatusSuccess      = "success"
+	StatusCompileError = "compile_error"
+	StatusError        = "error"
+)
+
+var collector *metrics // collector is nil, as long as metrics are disabled.
+
+type metrics struct {
+	registry      *prometheus.Registry
+	buildTotal    *prometheus.CounterVec
+	buildDuration prometheus.Histogram
+	wasmSize      prometheus.Gauge
+	requestsTotal *prometheus.CounterVec
+}
+
+// Enable activates the collection of metrics. It must be called before any other function is used
+// concurrently.
+func Enable() {
+	if collector != nil {
+		return
+	}
+
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		buildTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gotrino_build_total",
+			Help: "The total amount of builds by status.",
+		}, []string{"status"}),
+		buildDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gotrino_build_duration_seconds",
+			Help:    "The duration of builds.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		}),
+		wasmSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gotrino_wasm_size_bytes",
+			Help: "The size of the last successfully built wasm binary.",
+		}),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gotrino_http_requests_total",
+			Help: "The total amount of http requests by path and status.",
+		}, []string{"path", "status"}),
+	}
+
+	m.registry.MustRegister(m.buildTotal, m.buildDuration, m.wasmSize, m.requestsTotal)
+	collector = m
+}
+
+// Enabled returns true, if Enable has been called.
+func Enabled() bool {
+	return collector != nil
+}
+
+// Handler returns the http handler which exports all metrics. It returns nil, if metrics are disabled.
+func Handler() http.Handler {
+	if collector == nil {
+		return nil
+	}
+
+	return promhttp.HandlerFor(collector.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveBuild counts a build with the given status and records its duration.
+func ObserveBuild(status string, duration time.Duration) {
+	if collector == nil {
+		return
+	}
+
+	collector.buildTotal.WithLabelValues(status).Inc()
+	collector.buildDuration.Observe(duration.Seconds())
+}
+
+// SetWasmSize records the size of the last built wasm binary.
+func SetWasmSize(size int64) {
+	if collector == nil {
+		return
+	}
+
+	collector.wasmSize.Set(float64(size))
+}
+
+// Middleware counts all requests of the given handler. If metrics are disabled, the handler is returned as is.
+func Middleware(h http.Handler) http.Handler {
+	if collector == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		collector.requestsTotal.WithLabelValues(r.URL.Path, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// statusWriter remembers the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush forwards to the wrapped writer, so that streamed responses are not buffered.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
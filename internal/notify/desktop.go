@@ -0,0 +1,100 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify informs about build results by desktop notifications using the notification tool of the
+// current platform or by webhooks.
+package notify
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Title of all notifications.
+const Title = "gotrino-make"
+
+// The message is passed by the environment, so that it needs no quoting for the scripting languages.
+const (
+	envTitle   = "GOTRINO_NOTIFY_TITLE"
+	envMessage = "GOTRINO_NOTIFY_MESSAGE"
+)
+
+// NotifySuccess shows an informational notification. If the notification tool is not installed, nothing happens.
+func NotifySuccess(msg string) error {
+	return notify(command(runtime.GOOS, false, msg), msg)
+}
+
+// NotifyFailure shows an error notification. If the notification tool is not installed, nothing happens.
+func NotifyFailure(msg string) error {
+	return notify(command(runtime.GOOS, true, msg), msg)
+}
+
+// notify starts the command without waiting for it, because e.g. powershell keeps running while the
+// notification is visible.
+func notify(cmd *exec.Cmd, msg string) error {
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return nil
+	}
+
+	cmd.Env = append(os.Environ(), envTitle+"="+Title, envMessage+"="+msg)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to notify: %w", err)
+	}
+
+	go func() {
+		// release the process resources, the exit code of the notification tool is not relevant
+		_ = cmd.Wait()
+	}()
+
+	return nil
+}
+
+// command returns the notification tool of the given platform. The title and message are read from the
+// environment, except for notify-send, which expects them as arguments.
+func command(goos string, failure bool, msg string) *exec.Cmd {
+	switch goos {
+	case "darwin":
+		sound := ""
+		if failure {
+			sound = ` sound name "Basso"`
+		}
+
+		return exec.Command("osascript", "-e",
+			`display notification (system attribute "`+envMessage+`") with title (system attribute "`+envTitle+`")`+sound)
+	case "windows":
+		icon := "Info"
+		if failure {
+			icon = "Error"
+		}
+
+		return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; "+
+				"$n = New-Object System.Windows.Forms.NotifyIcon; "+
+				"$n.Icon = [System.Drawing.SystemIcons]::Information; "+
+				"$n.Visible = $true; "+
+				"$n.ShowBalloonTip(5000, $env:"+envTitle+", $env:"+envMessage+", '"+icon+"'); "+
+				"Start-Sleep -Seconds 6; "+
+				"$n.Dispose()")
+	default:
+		urgency := "normal"
+		if failure {
+			urgency = "critical"
+		}
+
+		return exec.Command("notify-send", "--urgency="+urgency, Title, msg)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	msg := `build "failed"`
+
+	cmd := command("linux", true, msg)
+	if got := strings.Join(cmd.Args, " "); got != "notify-send --urgency=critical gotrino-make "+msg {
+		t.Fatalf("unexpected notify-send args: %s", got)
+	}
+
+	// the message must never be part of a script, otherwise it would need quoting
+	for _, goos := range []string{"darwin", "windows"} {
+		cmd := command(goos, false, msg)
+		if strings.Contains(strings.Join(cmd.Args, " "), msg) {
+			t.Fatalf("%s: expected the message to be passed by the environment: %v", goos, cmd.Args)
+		}
+	}
+}
+
+func TestNotifyMissingTool(t *testing.T) {
+	if err := notify(exec.Command("gotrino-make-missing-notify-tool"), "hello"); err != nil {
+		t.Fatalf("expected a missing tool to be skipped but got %v", err)
+	}
+}
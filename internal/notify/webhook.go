@@ -0,0 +1,67 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookTimeout is the maximum duration of a PostBuildResult.
+const WebhookTimeout = 10 * time.Second
+
+// A BuildResult is posted as json to a webhook.
+type BuildResult struct {
+	Hash       string  `json:"hash"`        // Hash is the hex encoded hash of the build.
+	Error      *string `json:"error"`       // Error is nil, if the build succeeded.
+	DurationMs int64   `json:"duration_ms"` // DurationMs is the duration of the build in milliseconds.
+	Commit     string  `json:"commit"`      // Commit may be empty, if the project is not in a git repository.
+}
+
+// PostBuildResult sends the result as json to the given url. If secret is not empty, it is sent as bearer token.
+// Any status other than 2xx is returned as error.
+func PostBuildResult(url, secret string, result BuildResult) error {
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("unable to marshal build result: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("unable to create webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+
+	client := &http.Client{Timeout: WebhookTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post build result: %w", err)
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unable to post build result: %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostBuildResult(t *testing.T) {
+	var body, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		body = string(buf)
+		auth = r.Header.Get("Authorization")
+		if auth == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+
+	defer srv.Close()
+
+	if err := PostBuildResult(srv.URL, "s3cr3t", BuildResult{Hash: "abc", DurationMs: 42, Commit: "def"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if body != `{"hash":"abc","error":null,"duration_ms":42,"commit":"def"}` || auth != "Bearer s3cr3t" {
+		t.Fatalf("unexpected request: %s %s", auth, body)
+	}
+
+	msg := "compile error"
+	if err := PostBuildResult(srv.URL, "", BuildResult{Error: &msg}); err == nil {
+		t.Fatal("expected the status 401 to fail")
+	}
+
+	if body != `{"hash":"","error":"compile error","duration_ms":0,"commit":""}` {
+		t.Fatalf("unexpected request: %s", body)
+	}
+}
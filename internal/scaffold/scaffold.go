@@ -0,0 +1,180 @@
+// Copyright 2020 Torben Schinke
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scaffold creates the skeleton of a new gotrino project.
+package scaffold
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultGoVersion is used for the go directive, if the installed go version is unknown.
+const DefaultGoVersion = "1.15"
+
+// A File of the project skeleton.
+type File struct {
+	Name    string // Name is slash separated and relative to the project directory.
+	Content string
+}
+
+// Files returns the skeleton of a project with the given module name: a go.mod, the conventional wasm entry
+// point in cmd/wasm and a static/index.gohtml template, which loads the wasm module. The gotrino dependency
+// is imported by cmd/wasm/main.go, so that go mod tidy adds it with its latest version.
+func Files(module, goVersion string) []File {
+	if goVersion == "" {
+		goVersion = DefaultGoVersion
+	}
+
+	return []File{
+		{Name: "go.mod", Content: fmt.Sprintf("module %s\n\ngo %s\n", module, goVersion)},
+		{Name: "cmd/wasm/main.go", Content: mainGo},
+		{Name: "static/index.gohtml", Content: indexGoHTML},
+	}
+}
+
+// Init writes the skeleton into dir. It fails without writing anything, if any of the files already exists.
+func Init(dir, module, goVersion string) error {
+	if err := CheckModuleName(module); err != nil {
+		return err
+	}
+
+	files := Files(module, goVersion)
+	for _, file := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(file.Name))
+		if _, err := os.Stat(fname); err == nil {
+			return fmt.Errorf("refusing to overwrite existing file: %s", fname)
+		}
+	}
+
+	for _, file := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(fname), os.ModePerm); err != nil {
+			return fmt.Errorf("unable to create directory: %w", err)
+		}
+
+		if err := ioutil.WriteFile(fname, []byte(file.Content), 0644); err != nil {
+			return fmt.Errorf("unable to write file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CheckModuleName returns an error, if the name cannot be used as a module path.
+func CheckModuleName(module string) error {
+	if module == "" {
+		return fmt.Errorf("module name must not be empty")
+	}
+
+	if strings.ContainsAny(module, " \t\r\n\"'`\\") || strings.HasPrefix(module, "/") || strings.HasSuffix(module, "/") {
+		return fmt.Errorf("invalid module name: '%s'", module)
+	}
+
+	return nil
+}
+
+const mainGo = `package main
+
+import (
+	"github.com/golangee/dom"
+	"github.com/golangee/gotrino"
+	"github.com/golangee/gotrino-tailwind/button"
+)
+
+func main() {
+	// start your actual application, better refactor it into a call to internal/app
+	run()
+
+	// keep wasm alive, e.g. for click listeners
+	select {}
+}
+
+func run() {
+	// show error, if run fails with panic
+	defer dom.GlobalPanicHandler()
+
+	// render some component or html
+	gotrino.RenderBody(button.NewTextButton("hello world", func() {
+		panic("not yet implemented")
+	}))
+}
+`
+
+const indexGoHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>hello world</title>
+    <script src="{{.BasePath}}/wasm_exec.js"></script>
+</head>
+<body>
+{{if .HasError}}
+{{.ErrorHTML}}
+{{else if .Wasm}}
+<script>
+    const go = new Go();
+    WebAssembly.instantiateStreaming(fetch("{{.BasePath}}/app.wasm?v={{.Version}}"), go.importObject)
+        .then(result => go.run(result.instance));
+</script>
+{{end}}
+{{if .HotReload}}
+<script>
+    // the api of the development server is not served below the base path
+    async function poll() {
+        try {
+            const res = await fetch("/api/v1/poll/version");
+            const text = await res.text();
+            for (const line of text.split("\n")) {
+                if (line.trim() !== "" && JSON.parse(line).type === "change") {
+                    setTimeout(() => location.reload(), {{.ReloadDelayMs}});
+                    return;
+                }
+            }
+        } catch (e) {
+            await new Promise(resolve => setTimeout(resolve, 1000));
+        }
+
+        poll();
+    }
+
+    poll();
+</script>
+{{end}}
+{{if .WasmProfile}}
+<script>
+    // download the profile of app.wasm, when POST /api/v1/profile/stop follows /api/v1/profile/start
+    let profiling = false;
+    setInterval(async () => {
+        const state = await (await fetch("/api/v1/profile/state")).json();
+        if (state.running && !profiling && window.startProfile) {
+            profiling = true;
+            startProfile();
+        } else if (!state.running && profiling) {
+            profiling = false;
+            const a = document.createElement("a");
+            a.href = URL.createObjectURL(new Blob([stopProfile()]));
+            a.download = "{{.WasmProfile}}.pprof";
+            a.click();
+        }
+    }, 1000);
+</script>
+{{end}}
+</body>
+</html>
+`
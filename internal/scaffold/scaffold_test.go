@@ -0,0 +1,64 @@
+package scaffold
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotrino-scaffold")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := Init(dir, "example.com/hello", "1.21"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "module example.com/hello\n\ngo 1.21\n" {
+		t.Fatalf("unexpected go.mod: %s", string(buf))
+	}
+
+	buf, err = ioutil.ReadFile(filepath.Join(dir, "cmd", "wasm", "main.go"))
+	if err != nil || !strings.Contains(string(buf), "github.com/golangee/gotrino") {
+		t.Fatalf("expected main.go to import gotrino: %v", err)
+	}
+
+	buf, err = ioutil.ReadFile(filepath.Join(dir, "static", "index.gohtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the api is only served at the root
+	if strings.Contains(string(buf), "{{.BasePath}}/api/") {
+		t.Fatalf("expected the api to be fetched without base path: %s", string(buf))
+	}
+
+	if err := Init(dir, "example.com/hello", "1.21"); err == nil {
+		t.Fatal("expected an existing project not to be overwritten")
+	}
+}
+
+func TestCheckModuleName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"example.com/hello": true,
+		"hello":             true,
+		"":                  false,
+		"my project":        false,
+		"/abs":              false,
+	} {
+		if err := CheckModuleName(name); (err == nil) != valid {
+			t.Fatalf("%s: expected valid=%v but got %v", name, valid, err)
+		}
+	}
+}
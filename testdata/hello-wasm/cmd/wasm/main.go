@@ -0,0 +1,7 @@
+package main
+
+import "syscall/js"
+
+func main() {
+	js.Global().Get("document").Get("body").Set("innerHTML", "hello world")
+}